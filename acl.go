@@ -0,0 +1,538 @@
+package cask
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultUserName = "default"
+
+// User is an ACL user. Commands are tracked by name, so a rule such as
+// "+@read -get" is resolved against the command table when it is applied.
+type User struct {
+	name     string
+	enabled  bool
+	nopass   bool
+	password map[string]bool // sha256 hex digests
+	commands map[string]bool
+	allKeys  bool
+	patterns []string
+}
+
+func newUser(name string) *User {
+	return &User{
+		name:     name,
+		password: make(map[string]bool),
+		commands: make(map[string]bool),
+	}
+}
+
+// ACL holds the server's users. Clients refer to their user by name, so
+// changes made by ACL SETUSER apply to connections that are already
+// authenticated.
+type ACL struct {
+	mu    sync.RWMutex
+	users map[string]*User
+}
+
+func NewACL() *ACL {
+	def := newUser(defaultUserName)
+	def.enabled = true
+	def.nopass = true
+	def.allKeys = true
+	for _, cmd := range commandTable {
+		def.commands[cmd.name] = true
+	}
+	return &ACL{users: map[string]*User{defaultUserName: def}}
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// SetUser creates the user if needed and applies rules to it in order. If any
+// rule is invalid the user is left unchanged.
+func (a *ACL) SetUser(name string, rules []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	user := newUser(name)
+	if existing, ok := a.users[name]; ok {
+		user = existing.clone()
+	}
+	for _, rule := range rules {
+		if err := user.apply(rule); err != nil {
+			return fmt.Errorf("ERR Error in ACL SETUSER modifier '%s': %w", rule, err)
+		}
+	}
+	a.users[name] = user
+	return nil
+}
+
+func (a *ACL) DelUser(name string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.users[name]; !ok {
+		return false
+	}
+	delete(a.users, name)
+	return true
+}
+
+func (a *ACL) Users() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	names := make([]string, 0, len(a.users))
+	for name := range a.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetUser returns a copy of the named user, safe to read without the lock.
+func (a *ACL) GetUser(name string) (*User, bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	user, ok := a.users[name]
+	if !ok {
+		return nil, false
+	}
+	return user.clone(), true
+}
+
+// List describes every user as the rules that would recreate it, sorted by
+// name.
+func (a *ACL) List() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	names := make([]string, 0, len(a.users))
+	for name := range a.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, len(names))
+	for i, name := range names {
+		user := a.users[name]
+		rules := append([]string{"user", name}, user.flags()...)
+		for _, hash := range user.passwords() {
+			rules = append(rules, "#"+hash)
+		}
+		if keys := user.keyRules(); keys != "" {
+			rules = append(rules, keys)
+		}
+		rules = append(rules, user.commandRules())
+		lines[i] = strings.Join(rules, " ")
+	}
+	return lines
+}
+
+// AuthRequired reports whether new connections must authenticate before
+// running commands, which is the case while the default user needs a password
+// or is disabled.
+func (a *ACL) AuthRequired() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	def := a.users[defaultUserName]
+	return !def.nopass || !def.enabled
+}
+
+// Authenticate reports whether password is valid for the enabled user name.
+func (a *ACL) Authenticate(name, password string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	user, ok := a.users[name]
+	if !ok || !user.enabled {
+		return false
+	}
+	return user.nopass || user.password[hashPassword(password)]
+}
+
+// Check returns a NOPERM error if the user may not run cmd with args, either
+// because the command is not allowed or because one of its keys matches none
+// of the user's key patterns. ACL WHOAMI is always allowed, since a user with
+// no admin rights is the one most likely to need it.
+func (a *ACL) Check(name string, cmd *command, args []string) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	user, ok := a.users[name]
+	if ok && cmd.name == "acl" && len(args) == 2 && strings.EqualFold(args[1], "WHOAMI") {
+		return nil
+	}
+	if !ok || !user.commands[cmd.name] {
+		return fmt.Errorf("NOPERM User %s has no permissions to run the '%s' command", name, cmd.name)
+	}
+	if user.allKeys {
+		return nil
+	}
+	for _, key := range cmd.keys(args) {
+		if !user.matchesKey(key) {
+			return errors.New("NOPERM No permissions to access a key")
+		}
+	}
+	return nil
+}
+
+func (u *User) clone() *User {
+	c := newUser(u.name)
+	c.enabled = u.enabled
+	c.nopass = u.nopass
+	c.allKeys = u.allKeys
+	for k := range u.password {
+		c.password[k] = true
+	}
+	for k := range u.commands {
+		c.commands[k] = true
+	}
+	c.patterns = append([]string(nil), u.patterns...)
+	return c
+}
+
+func (u *User) flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+func (u *User) passwords() []string {
+	hashes := make([]string, 0, len(u.password))
+	for hash := range u.password {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+func (u *User) keyRules() string {
+	if u.allKeys {
+		return "~*"
+	}
+	rules := make([]string, len(u.patterns))
+	for i, pattern := range u.patterns {
+		rules[i] = "~" + pattern
+	}
+	return strings.Join(rules, " ")
+}
+
+// commandRules describes the allowed commands relative to whichever of +@all
+// or -@all needs fewer exceptions.
+func (u *User) commandRules() string {
+	var allowed, denied []string
+	for _, cmd := range commandTable {
+		if u.commands[cmd.name] {
+			allowed = append(allowed, "+"+cmd.name)
+		} else {
+			denied = append(denied, "-"+cmd.name)
+		}
+	}
+	sort.Strings(allowed)
+	sort.Strings(denied)
+	if len(denied) <= len(allowed) {
+		return strings.Join(append([]string{"+@all"}, denied...), " ")
+	}
+	return strings.Join(append([]string{"-@all"}, allowed...), " ")
+}
+
+func (u *User) matchesKey(key string) bool {
+	for _, pattern := range u.patterns {
+		if match, _ := filepath.Match(pattern, key); match {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *User) apply(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "nopass":
+		u.nopass = true
+		u.password = make(map[string]bool)
+		return nil
+	case "resetpass":
+		u.nopass = false
+		u.password = make(map[string]bool)
+		return nil
+	case "allkeys":
+		u.allKeys = true
+		u.patterns = nil
+		return nil
+	case "resetkeys":
+		u.allKeys = false
+		u.patterns = nil
+		return nil
+	case "allcommands":
+		return u.setCommands("@all", true)
+	case "nocommands":
+		return u.setCommands("@all", false)
+	case "reset":
+		*u = *newUser(u.name)
+		return nil
+	}
+
+	if rule == "" {
+		return errors.New("Syntax error")
+	}
+	switch rule[0] {
+	case '>':
+		u.nopass = false
+		u.password[hashPassword(rule[1:])] = true
+	case '<':
+		delete(u.password, hashPassword(rule[1:]))
+	case '~':
+		if rule == "~*" {
+			u.allKeys = true
+			u.patterns = nil
+			return nil
+		}
+		if u.allKeys {
+			return errors.New("Adding a pattern after the * pattern (or the 'allkeys' flag) is not valid and does not have any effect. Try 'resetkeys' to start with an empty list of patterns")
+		}
+		// Match reports a malformed pattern whatever the name, so check it
+		// here rather than have it silently match nothing in Check.
+		if _, err := filepath.Match(rule[1:], ""); err != nil {
+			return errors.New("Invalid key pattern")
+		}
+		u.patterns = append(u.patterns, rule[1:])
+	case '+':
+		return u.setCommands(rule[1:], true)
+	case '-':
+		return u.setCommands(rule[1:], false)
+	default:
+		return errors.New("Syntax error")
+	}
+	return nil
+}
+
+// setCommands allows or denies a single command, or with a leading "@" every
+// command in a category.
+func (u *User) setCommands(name string, allow bool) error {
+	if !strings.HasPrefix(name, "@") {
+		cmd, ok := lookupCommand(name)
+		if !ok {
+			return errors.New("Unknown command or category name in ACL")
+		}
+		u.commands[cmd.name] = allow
+		return nil
+	}
+	category := strings.ToLower(name[1:])
+	if category != "all" && categoryFlag(category) == 0 {
+		return errors.New("Unknown command or category name in ACL")
+	}
+	for _, cmd := range commandTable {
+		if category == "all" || cmd.flags&categoryFlag(category) != 0 {
+			u.commands[cmd.name] = allow
+		}
+	}
+	return nil
+}
+
+// categoryFlag maps an ACL category to the command flag that defines it.
+func categoryFlag(category string) int {
+	switch category {
+	case "read":
+		return flagReadonly
+	case "write":
+		return flagWrite
+	case "admin":
+		return flagAdmin
+	}
+	return 0
+}
+
+var aclHelp = []string{
+	"SETUSER <username> [<rule> ...]",
+	"    Create or modify a user with the specified rules.",
+	"DELUSER <username> [<username> ...]",
+	"    Delete a list of users.",
+	"USERS",
+	"    List all the registered usernames.",
+	"GETUSER <username>",
+	"    Get the user's details.",
+	"LIST",
+	"    Show users details in config file format.",
+	"WHOAMI",
+	"    Return the current connection username.",
+}
+
+func handleACL(srv *Server, c *Client, args []string) {
+	switch strings.ToUpper(args[1]) {
+	case "HELP":
+		c.writeHelp("ACL", aclHelp)
+	case "SETUSER":
+		if len(args) < 3 {
+			c.writeError("ERR wrong number of arguments for 'acl|setuser' command")
+			return
+		}
+		if err := srv.acl.SetUser(args[2], args[3:]); err != nil {
+			c.writeError(err.Error())
+			return
+		}
+		c.writeSimple("OK")
+	case "DELUSER":
+		if len(args) < 3 {
+			c.writeError("ERR wrong number of arguments for 'acl|deluser' command")
+			return
+		}
+		deleted := 0
+		for _, name := range args[2:] {
+			if name == defaultUserName {
+				c.writeError("ERR The 'default' user cannot be removed")
+				return
+			}
+		}
+		for _, name := range args[2:] {
+			if srv.acl.DelUser(name) {
+				srv.clients.Kill(func(other *Client) bool { return other.User() == name })
+				deleted++
+			}
+		}
+		c.writeInt(int64(deleted))
+	case "USERS":
+		c.writeBulkArray(srv.acl.Users())
+	case "GETUSER":
+		if len(args) != 3 {
+			c.writeError("ERR wrong number of arguments for 'acl|getuser' command")
+			return
+		}
+		user, ok := srv.acl.GetUser(args[2])
+		if !ok {
+			c.writeNil()
+			return
+		}
+		c.writeMapLen(4)
+		c.writeBulk("flags")
+		c.writeBulkArray(user.flags())
+		c.writeBulk("passwords")
+		c.writeBulkArray(user.passwords())
+		c.writeBulk("commands")
+		c.writeBulk(user.commandRules())
+		c.writeBulk("keys")
+		c.writeBulk(user.keyRules())
+	case "LIST":
+		c.writeBulkArray(srv.acl.List())
+	case "WHOAMI":
+		c.writeBulk(c.User())
+	default:
+		c.writeUnknownSubcommand("ACL", args[1])
+	}
+}
+
+func handleAuth(srv *Server, c *Client, args []string) {
+	var name, password string
+	switch len(args) {
+	case 2:
+		if !srv.acl.AuthRequired() {
+			c.writeError("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+			return
+		}
+		name, password = defaultUserName, args[1]
+	case 3:
+		name, password = args[1], args[2]
+	default:
+		c.writeError("ERR syntax error")
+		return
+	}
+	if !srv.acl.Authenticate(name, password) {
+		c.writeError("WRONGPASS invalid username-password pair or user is disabled.")
+		return
+	}
+	c.Authenticate(name)
+	c.writeSimple("OK")
+}
+
+// handleHello negotiates the protocol and optionally authenticates and names
+// the connection. The protocol only switches once authentication succeeds, so
+// a failed HELLO 3 AUTH leaves the client on its old protocol.
+func handleHello(srv *Server, c *Client, args []string) {
+	resp := c.resp
+	if len(args) > 1 {
+		protover, err := strconv.Atoi(args[1])
+		if err != nil {
+			c.writeError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if protover != 2 && protover != 3 {
+			c.writeError("NOPROTO unsupported protocol version")
+			return
+		}
+		resp = protover
+	}
+
+	var user, password, name string
+	authenticate := false
+	for i := 2; i < len(args); i++ {
+		switch {
+		case strings.ToUpper(args[i]) == "AUTH" && i+2 < len(args):
+			authenticate = true
+			user, password = args[i+1], args[i+2]
+			i += 2
+		case strings.ToUpper(args[i]) == "SETNAME" && i+1 < len(args):
+			name = args[i+1]
+			if !validClientName(name) {
+				c.writeError("ERR Client names cannot contain spaces, newlines or special characters.")
+				return
+			}
+			i++
+		default:
+			c.writeError(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", args[i]))
+			return
+		}
+	}
+
+	if authenticate {
+		if !srv.acl.Authenticate(user, password) {
+			c.writeError("WRONGPASS invalid username-password pair or user is disabled.")
+			return
+		}
+		c.Authenticate(user)
+	} else if !c.Authenticated() {
+		c.writeError("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+		return
+	}
+	if name != "" {
+		c.SetName(name)
+	}
+	c.SetProtocol(resp)
+
+	c.writeMapLen(7)
+	c.writeBulk("server")
+	c.writeBulk("redis")
+	c.writeBulk("version")
+	c.writeBulk(redisVersion)
+	c.writeBulk("proto")
+	c.writeInt(int64(resp))
+	c.writeBulk("id")
+	c.writeInt(c.id)
+	c.writeBulk("mode")
+	c.writeBulk("standalone")
+	c.writeBulk("role")
+	c.writeBulk("master")
+	c.writeBulk("modules")
+	c.writeArrayLen(0)
+}
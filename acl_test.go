@@ -0,0 +1,161 @@
+package cask
+
+import (
+	"strings"
+	"testing"
+)
+
+const noAuth = "-NOAUTH Authentication required.\r\n"
+
+// TestSetPasswordKeepsSessions checks that giving the default user a password
+// only affects connections made afterwards, as in Redis.
+func TestSetPasswordKeepsSessions(t *testing.T) {
+	srv := newTestServer(t, Config{})
+	admin := newTestClient(t, srv)
+	other := newTestClient(t, srv)
+	run(t, admin, [][2]string{
+		{"ACL|SETUSER|default|resetpass|>pw", "+OK\r\n"},
+		{"SET|k|v", "+OK\r\n"},
+	})
+	run(t, other, [][2]string{{"GET|k", "$1\r\nv\r\n"}})
+
+	late := newTestClient(t, srv)
+	run(t, late, [][2]string{
+		{"GET|k", noAuth},
+		{"PING", "+PONG\r\n"},
+		{"AUTH|wrong", "-WRONGPASS invalid username-password pair or user is disabled.\r\n"},
+		{"GET|k", noAuth},
+		{"AUTH|pw", "+OK\r\n"},
+		{"GET|k", "$1\r\nv\r\n"},
+		{"RESET", "+RESET\r\n"},
+		{"GET|k", noAuth},
+	})
+
+	run(t, admin, [][2]string{{"ACL|SETUSER|default|nopass", "+OK\r\n"}})
+	run(t, late, [][2]string{{"RESET", "+RESET\r\n"}, {"GET|k", "$1\r\nv\r\n"}})
+	run(t, newTestClient(t, srv), [][2]string{{"GET|k", "$1\r\nv\r\n"}})
+}
+
+func TestHelloAuthRESP3(t *testing.T) {
+	srv := newTestServer(t, Config{})
+	if err := srv.acl.SetUser(defaultUserName, []string{"resetpass", ">pw"}); err != nil {
+		t.Fatal(err)
+	}
+	tc := newTestClient(t, srv)
+	run(t, tc, [][2]string{
+		{"HELLO|3", "-NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time\r\n"},
+		{"HELLO|3|AUTH|default|wrong", "-WRONGPASS invalid username-password pair or user is disabled.\r\n"},
+		{"HELLO|4|AUTH|default|pw", "-NOPROTO unsupported protocol version\r\n"},
+		{"GET|missing", noAuth},
+	})
+
+	reply := tc.do("HELLO", "3", "AUTH", "default", "pw")
+	if !strings.HasPrefix(reply, "%7\r\n$6\r\nserver\r\n") || !strings.Contains(reply, "$5\r\nproto\r\n:3\r\n") {
+		t.Fatalf("HELLO 3 AUTH reply = %q, want a RESP3 map with proto 3", reply)
+	}
+	run(t, tc, [][2]string{
+		{"GET|missing", "_\r\n"},
+		{"HSET|h|f|v", ":1\r\n"},
+		{"HGETALL|h", "%1\r\n$1\r\nf\r\n$1\r\nv\r\n"},
+		{"SADD|s|m", ":1\r\n"},
+		{"SMEMBERS|s", "~1\r\n$1\r\nm\r\n"},
+		{"LPOP|nolist|2", "_\r\n"},
+		{"ACL|WHOAMI", "$7\r\ndefault\r\n"},
+	})
+
+	reply = tc.do("HELLO", "2")
+	if !strings.HasPrefix(reply, "*14\r\n") || !strings.Contains(reply, "$5\r\nproto\r\n:2\r\n") {
+		t.Fatalf("HELLO 2 reply = %q, want a flat array with proto 2", reply)
+	}
+	run(t, tc, [][2]string{
+		{"GET|missing", "$-1\r\n"},
+		{"HGETALL|h", "*2\r\n$1\r\nf\r\n$1\r\nv\r\n"},
+	})
+
+	// An authenticated client may switch protocol without AUTH, and RESET
+	// switches it back along with the authentication.
+	if reply := tc.do("HELLO", "3"); !strings.HasPrefix(reply, "%7\r\n") {
+		t.Fatalf("HELLO 3 reply = %q", reply)
+	}
+	run(t, tc, [][2]string{
+		{"RESET", "+RESET\r\n"},
+		{"GET|missing", noAuth},
+	})
+	if tc.c.resp != 2 {
+		t.Errorf("RESET left the client on RESP%d", tc.c.resp)
+	}
+}
+
+// TestKeyPatternsCoverSubcommandKeys checks that keys named by a subcommand,
+// not just fixed key positions, are held to the user's key patterns.
+func TestKeyPatternsCoverSubcommandKeys(t *testing.T) {
+	srv := newTestServer(t, Config{})
+	admin := newTestClient(t, srv)
+	run(t, admin, [][2]string{
+		{"SET|app:1|v", "+OK\r\n"},
+		{"SET|secret|v", "+OK\r\n"},
+		{"ACL|SETUSER|app|on|>pw|~app:*|+@read|+debug", "+OK\r\n"},
+	})
+
+	const noKey = "-NOPERM No permissions to access a key\r\n"
+	tc := newTestClient(t, srv)
+	run(t, tc, [][2]string{
+		{"AUTH|app|pw", "+OK\r\n"},
+		{"GET|app:1", "$1\r\nv\r\n"},
+		{"GET|secret", noKey},
+		{"OBJECT|ENCODING|app:1", "$3\r\nraw\r\n"},
+		{"OBJECT|ENCODING|secret", noKey},
+		{"object|encoding|secret", noKey},
+		{"MEMORY|USAGE|secret", noKey},
+		{"DEBUG|OBJECT|secret", noKey},
+	})
+	if reply := tc.do("OBJECT", "HELP"); !strings.HasPrefix(reply, "*5\r\n") {
+		t.Errorf("OBJECT HELP = %q, want the help text", reply)
+	}
+	if reply := tc.do("MEMORY", "USAGE", "app:1"); !strings.HasPrefix(reply, ":") {
+		t.Errorf("MEMORY USAGE app:1 = %q, want an integer", reply)
+	}
+	if reply := tc.do("DEBUG", "OBJECT", "app:1"); !strings.HasPrefix(reply, "+type:string") {
+		t.Errorf("DEBUG OBJECT app:1 = %q", reply)
+	}
+}
+
+func TestSetUserKeyPatterns(t *testing.T) {
+	srv := newTestServer(t, Config{})
+	tc := newTestClient(t, srv)
+	const afterAll = "Adding a pattern after the * pattern (or the 'allkeys' flag) is not valid and does not have any effect. Try 'resetkeys' to start with an empty list of patterns\r\n"
+	run(t, tc, [][2]string{
+		{"ACL|SETUSER|u|~*|~foo:*", "-ERR Error in ACL SETUSER modifier '~foo:*': " + afterAll},
+		{"ACL|SETUSER|u|allkeys|~foo:*", "-ERR Error in ACL SETUSER modifier '~foo:*': " + afterAll},
+		{"ACL|SETUSER|u|~foo:[", "-ERR Error in ACL SETUSER modifier '~foo:[': Invalid key pattern\r\n"},
+		{"ACL|SETUSER|u|~a\\", "-ERR Error in ACL SETUSER modifier '~a\\': Invalid key pattern\r\n"},
+		{"ACL|SETUSER|u|~foo:*|~bar:[ab]|~*", "+OK\r\n"},
+		{"ACL|SETUSER|u|resetkeys|~foo:*", "+OK\r\n"},
+	})
+
+	// A rejected rule leaves the user as it was.
+	run(t, tc, [][2]string{
+		{"ACL|SETUSER|v|~foo:*", "+OK\r\n"},
+		{"ACL|SETUSER|v|~bar:*|~[", "-ERR Error in ACL SETUSER modifier '~[': Invalid key pattern\r\n"},
+	})
+	if user, _ := srv.acl.GetUser("v"); user == nil || strings.Join(user.patterns, " ") != "foo:*" {
+		t.Errorf("user after a rejected SETUSER = %+v, want patterns [foo:*]", user)
+	}
+}
+
+func TestWhoAmIWithoutAdmin(t *testing.T) {
+	srv := newTestServer(t, Config{})
+	run(t, newTestClient(t, srv), [][2]string{
+		{"ACL|SETUSER|reader|on|>pw|~*|+@read", "+OK\r\n"},
+	})
+
+	tc := newTestClient(t, srv)
+	run(t, tc, [][2]string{
+		{"AUTH|reader|pw", "+OK\r\n"},
+		{"ACL|WHOAMI", "$6\r\nreader\r\n"},
+		{"acl|whoami", "$6\r\nreader\r\n"},
+		{"ACL|USERS", "-NOPERM User reader has no permissions to run the 'acl' command\r\n"},
+		{"ACL|SETUSER|reader|+@all", "-NOPERM User reader has no permissions to run the 'acl' command\r\n"},
+		{"ACL|WHOAMI|extra", "-NOPERM User reader has no permissions to run the 'acl' command\r\n"},
+	})
+}
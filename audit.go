@@ -0,0 +1,96 @@
+package cask
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditQueueLen is how many entries may wait for the writer goroutine before
+// logging a command blocks it.
+const auditQueueLen = 4096
+
+// auditLog appends one line per write command to a file. Commands format and
+// queue their line; a single goroutine writes them, flushing whenever the
+// queue runs dry.
+type auditLog struct {
+	file    *os.File
+	redact  bool
+	logger  *log.Logger
+	entries chan string
+	done    chan struct{}
+}
+
+func openAuditLog(path string, redact bool, logger *log.Logger) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	a := &auditLog{
+		file:    file,
+		redact:  redact,
+		logger:  logger,
+		entries: make(chan string, auditQueueLen),
+		done:    make(chan struct{}),
+	}
+	go a.run()
+	return a, nil
+}
+
+// Record queues an entry for a write command that has run, with the first line
+// of its reply as the outcome, so that for instance a SET NX that didn't apply
+// shows a nil reply. With redaction only the command and its keys are written,
+// not the other arguments.
+func (a *auditLog) Record(c *Client, cmd *command, args []string, reply string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s addr=%s user=%s cmd=%s", time.Now().UTC().Format(time.RFC3339Nano), c.addr, c.User(), cmd.name)
+	b.WriteString(" keys=")
+	writeQuoted(&b, cmd.keys(args))
+	if !a.redact {
+		b.WriteString(" args=")
+		writeQuoted(&b, args[1:])
+	}
+	if a.redact && strings.HasPrefix(reply, ":") {
+		reply = ":"
+	}
+	b.WriteString(" reply=" + strconv.Quote(reply))
+	b.WriteString("\n")
+	a.entries <- b.String()
+}
+
+func writeQuoted(b *strings.Builder, items []string) {
+	for i, item := range items {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(strconv.Quote(item))
+	}
+}
+
+func (a *auditLog) run() {
+	defer close(a.done)
+	w := bufio.NewWriter(a.file)
+	for entry := range a.entries {
+		w.WriteString(entry)
+		if len(a.entries) == 0 {
+			if err := w.Flush(); err != nil {
+				a.logger.Println("Error writing audit log:", err)
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		a.logger.Println("Error writing audit log:", err)
+	}
+}
+
+// Close writes out every queued entry and closes the file. No entries may be
+// recorded after it is called.
+func (a *auditLog) Close() error {
+	close(a.entries)
+	<-a.done
+	return a.file.Close()
+}
@@ -0,0 +1,334 @@
+package cask
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Client struct {
+	id        int64
+	conn      net.Conn
+	r         *bufio.Reader
+	w         *bufio.Writer
+	addr      string
+	createdAt time.Time
+
+	limiter *rateLimiter
+	// killed is closed when the client is killed, waking it if it is
+	// blocked in a command.
+	killed chan struct{}
+
+	mu      sync.Mutex
+	name    string
+	noEvict bool
+	user    string
+	// authenticated is set when the client connects while the default user
+	// needs no password, and by a successful AUTH or HELLO AUTH. Changing
+	// the default user's password later doesn't affect existing sessions.
+	authenticated bool
+	// resp is the protocol version chosen with HELLO, 2 or 3. It is only
+	// changed by the client's own goroutine, which reads it without the lock
+	// when writing replies.
+	resp int
+	// quit closes the connection once the current reply is sent.
+	quit bool
+	// reply is the first line of the current command's reply.
+	reply string
+}
+
+type ClientRegistry struct {
+	mu      sync.Mutex
+	nextID  int64
+	clients map[int64]*Client
+}
+
+func NewClientRegistry() *ClientRegistry {
+	return &ClientRegistry{
+		clients: make(map[int64]*Client),
+	}
+}
+
+func (r *ClientRegistry) Register(conn net.Conn) *Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	client := &Client{
+		id:        r.nextID,
+		conn:      conn,
+		w:         bufio.NewWriter(conn),
+		addr:      clientAddr(conn, r.nextID),
+		createdAt: time.Now(),
+		user:      defaultUserName,
+		resp:      2,
+		killed:    make(chan struct{}),
+	}
+	r.clients[client.id] = client
+	return client
+}
+
+// clientAddr reports Unix socket peers by the listening socket path and their
+// client ID, since they have no address of their own and CLIENT KILL ADDR must
+// still tell them apart.
+func clientAddr(conn net.Conn, id int64) string {
+	if _, ok := conn.LocalAddr().(*net.UnixAddr); ok {
+		return conn.LocalAddr().String() + ":" + strconv.FormatInt(id, 10)
+	}
+	return conn.RemoteAddr().String()
+}
+
+func (r *ClientRegistry) Unregister(id int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.clients, id)
+}
+
+func (r *ClientRegistry) List() []*Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := make([]*Client, 0, len(r.clients))
+	for _, client := range r.clients {
+		list = append(list, client)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	return list
+}
+
+func (r *ClientRegistry) Count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.clients)
+}
+
+// Kill closes and unregisters every client accepted by match. Closing the conn
+// is safe while its handler is blocked in a read; the read fails and the
+// handler exits.
+func (r *ClientRegistry) Kill(match func(*Client) bool) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	killed := 0
+	for _, client := range r.clients {
+		if match(client) {
+			client.conn.Close()
+			close(client.killed)
+			delete(r.clients, client.id)
+			killed++
+		}
+	}
+	return killed
+}
+
+// watchClose reports on the returned channel when the peer closes the
+// connection, for commands that block without reading from it. Anything the
+// client pipelines meanwhile stays buffered for the next command. stop ends
+// the watch and must be called before the connection is read again.
+func (c *Client) watchClose() (closed <-chan struct{}, stop func()) {
+	ch := make(chan struct{})
+	if c.r == nil {
+		return ch, func() {}
+	}
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		// Peek for one byte more than is buffered until the buffer is full;
+		// it only fails on EOF, a broken connection or the deadline stop sets.
+		for n := c.r.Buffered() + 1; n <= c.r.Size(); n = c.r.Buffered() + 1 {
+			if _, err := c.r.Peek(n); err != nil {
+				if !errors.Is(err, os.ErrDeadlineExceeded) {
+					close(ch)
+				}
+				return
+			}
+		}
+	}()
+	return ch, func() {
+		c.conn.SetReadDeadline(time.Now())
+		<-exited
+		c.conn.SetReadDeadline(time.Time{})
+	}
+}
+
+func (c *Client) Name() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.name
+}
+
+func (c *Client) SetName(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.name = name
+}
+
+// User is the name of the ACL user the client is authenticated as.
+func (c *Client) User() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.user
+}
+
+// Authenticate switches the client to the ACL user name.
+func (c *Client) Authenticate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.user = name
+	c.authenticated = true
+}
+
+func (c *Client) Authenticated() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.authenticated
+}
+
+func (c *Client) SetProtocol(resp int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.resp = resp
+}
+
+func (c *Client) SetNoEvict(on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.noEvict = on
+}
+
+// Reset returns the connection to the state of a freshly accepted one,
+// authenticated as the default user if that user needs no password.
+func (c *Client) Reset(authenticated bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.name = ""
+	c.noEvict = false
+	c.user = defaultUserName
+	c.authenticated = authenticated
+	c.resp = 2
+}
+
+func (c *Client) Info() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	flags := "N"
+	if c.noEvict {
+		flags = "e"
+	}
+	age := int(time.Since(c.createdAt).Seconds())
+	return fmt.Sprintf("id=%d addr=%s name=%s age=%d flags=%s user=%s resp=%d", c.id, c.addr, c.name, age, flags, c.user, c.resp)
+}
+
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+var clientHelp = []string{
+	"ID",
+	"    Return the ID of the current connection.",
+	"LIST",
+	"    Return information about client connections.",
+	"KILL ID <id> | ADDR <ip:port>",
+	"    Kill the connection with the given id or address.",
+	"SETNAME <name>",
+	"    Assign the name <name> to the current connection.",
+	"GETNAME",
+	"    Return the name of the current connection.",
+	"NO-EVICT (ON|OFF)",
+	"    Protect the current connection from client eviction.",
+}
+
+func handleClient(srv *Server, c *Client, args []string) {
+	switch strings.ToUpper(args[1]) {
+	case "HELP":
+		c.writeHelp("CLIENT", clientHelp)
+	case "ID":
+		c.writeInt(c.id)
+	case "LIST":
+		var b strings.Builder
+		for _, other := range srv.clients.List() {
+			b.WriteString(other.Info())
+			b.WriteString("\n")
+		}
+		c.writeBulk(b.String())
+	case "KILL":
+		if len(args) != 4 {
+			c.writeError("ERR CLIENT KILL needs ID <id> or ADDR <addr>")
+			return
+		}
+		var killed int
+		switch strings.ToUpper(args[2]) {
+		case "ID":
+			id, err := strconv.ParseInt(args[3], 10, 64)
+			if err != nil {
+				c.writeError("ERR client-id should be an integer")
+				return
+			}
+			killed = srv.clients.Kill(func(other *Client) bool { return other.id == id })
+		case "ADDR":
+			killed = srv.clients.Kill(func(other *Client) bool { return other.addr == args[3] })
+		default:
+			c.writeError("ERR CLIENT KILL needs ID <id> or ADDR <addr>")
+			return
+		}
+		c.writeInt(int64(killed))
+	case "SETNAME":
+		if len(args) != 3 {
+			c.writeError("ERR CLIENT SETNAME needs 1 argument")
+			return
+		}
+		if !validClientName(args[2]) {
+			c.writeError("ERR Client names cannot contain spaces, newlines or special characters.")
+			return
+		}
+		c.SetName(args[2])
+		c.writeSimple("OK")
+	case "GETNAME":
+		name := c.Name()
+		if name == "" {
+			c.writeNil()
+		} else {
+			c.writeBulk(name)
+		}
+	case "NO-EVICT":
+		if len(args) != 3 {
+			c.writeError("ERR CLIENT NO-EVICT needs ON or OFF")
+			return
+		}
+		switch strings.ToUpper(args[2]) {
+		case "ON":
+			c.SetNoEvict(true)
+		case "OFF":
+			c.SetNoEvict(false)
+		default:
+			c.writeError("ERR CLIENT NO-EVICT needs ON or OFF")
+			return
+		}
+		c.writeSimple("OK")
+	default:
+		c.writeUnknownSubcommand("CLIENT", args[1])
+	}
+}
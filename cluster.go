@@ -0,0 +1,43 @@
+package cask
+
+import "strings"
+
+var clusterHelp = []string{
+	"INFO",
+	"    Return information about the cluster.",
+	"MYID",
+	"    Return the node id.",
+	"SHARDS",
+	"    Return information about slot range mappings and the nodes serving them.",
+	"SLOTS",
+	"    Return information about slots range mappings.",
+}
+
+// handleCluster answers the CLUSTER queries cluster-aware clients send while
+// connecting. Cask is a single node without cluster support, so there are no
+// slots or shards to report.
+func handleCluster(srv *Server, c *Client, args []string) {
+	switch strings.ToUpper(args[1]) {
+	case "HELP":
+		c.writeHelp("CLUSTER", clusterHelp)
+	case "INFO":
+		c.writeBulk(strings.Join([]string{
+			"cluster_enabled:0",
+			"cluster_state:ok",
+			"cluster_slots_assigned:0",
+			"cluster_slots_ok:0",
+			"cluster_slots_pfail:0",
+			"cluster_slots_fail:0",
+			"cluster_known_nodes:1",
+			"cluster_size:0",
+			"cluster_current_epoch:0",
+			"cluster_my_epoch:0",
+		}, "\r\n") + "\r\n")
+	case "MYID":
+		c.writeBulk(srv.nodeID)
+	case "SLOTS", "SHARDS":
+		c.writeArrayLen(0)
+	default:
+		c.writeUnknownSubcommand("CLUSTER", args[1])
+	}
+}
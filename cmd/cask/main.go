@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/olivemonk/cask"
+)
+
+const serverPort = "6380"
+
+// stringList is a flag that may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ", ") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func main() {
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file; enables TLS when set")
+	tlsKey := flag.String("tls-key", "", "TLS private key file")
+	tlsCA := flag.String("tls-ca", "", "CA file used to require and verify client certificates")
+	tlsPort := flag.String("tls-port", "", "serve TLS on this port and plain TCP on the main port; if empty the main port uses TLS")
+	unixSocket := flag.String("unixsocket", "", "also listen on this Unix domain socket path")
+	metricsAddr := flag.String("metrics-addr", "", "serve Prometheus metrics on this address at /metrics")
+	clientRateLimit := flag.Int("client-rate-limit", 0, "max commands per second per connection, 0 for unlimited")
+	protoMaxBulkLen := flag.Int64("proto-max-bulk-len", cask.DefaultProtoMaxBulkLen, "max size in bytes of a single bulk string sent by a client")
+	protoMaxLineLen := flag.Int("proto-max-line-len", cask.DefaultProtoMaxLineLen, "max length in bytes of a protocol header line sent by a client")
+	maxValueSize := flag.Int64("max-value-size", 0, "max size in bytes of a stored value, 0 for unlimited")
+	expireJitter := flag.Int("expire-jitter", 0, "randomly spread relative TTLs by up to this percentage either way, 0 to disable")
+	writeTimeout := flag.Int("write-timeout", 60, "seconds a reply write may block before the client is disconnected, 0 for no limit")
+	tcpKeepAlive := flag.Int("tcp-keepalive", 300, "TCP keepalive period in seconds for client connections, 0 to disable")
+	latencyThreshold := flag.Int("latency-monitor-threshold", 0, "record operations slower than this many milliseconds, 0 to disable")
+	auditLog := flag.String("audit-log", "", "append a line for every write command to this file")
+	auditRedact := flag.Bool("audit-redact-values", false, "log only the command and keys of write commands, not their values")
+	showVersion := flag.Bool("version", false, "print version information and exit")
+	requirePass := flag.String("requirepass", "", "password clients must AUTH with as the default user")
+	var users stringList
+	flag.Var(&users, "user", "define an ACL user as \"name rule ...\"; may be repeated")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Printf("cask %s (built %s, %s)\n", cask.Version, cask.BuildDate, runtime.Version())
+		return
+	}
+	fmt.Printf("CASK %s (built %s)\n", cask.Version, cask.BuildDate)
+
+	config := cask.Config{
+		Addr:        ":" + serverPort,
+		TLSCertFile: *tlsCert,
+		TLSKeyFile:  *tlsKey,
+		TLSCAFile:   *tlsCA,
+		UnixSocket:  *unixSocket,
+		MetricsAddr: *metricsAddr,
+
+		ClientRateLimit: *clientRateLimit,
+		ProtoMaxBulkLen: *protoMaxBulkLen,
+		ProtoMaxLineLen: *protoMaxLineLen,
+		MaxValueSize:    *maxValueSize,
+
+		LatencyMonitorThreshold: time.Duration(*latencyThreshold) * time.Millisecond,
+		TCPKeepAlive:            time.Duration(*tcpKeepAlive) * time.Second,
+		WriteTimeout:            time.Duration(*writeTimeout) * time.Second,
+		ExpireJitter:            *expireJitter,
+
+		AuditLog:          *auditLog,
+		AuditRedactValues: *auditRedact,
+
+		RequirePass: *requirePass,
+		Users:       users,
+
+		Logger: log.Default(),
+	}
+	if *tlsPort != "" {
+		config.TLSAddr = ":" + *tlsPort
+	}
+	srv := cask.NewServer(config)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		fmt.Println("CASK shutting down")
+		srv.Close()
+	}()
+
+	if err := srv.Listen(); err != nil {
+		log.Fatal("Error starting server: ", err)
+	}
+	fmt.Println("CASK server started on:", srv.Addr())
+	srv.Serve()
+}
@@ -0,0 +1,786 @@
+package cask
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	flagReadonly = 1 << iota
+	flagWrite
+	flagAdmin
+	// flagNoAuth commands may run before the client has authenticated and
+	// are never denied by the ACL. They must be harmless to anyone.
+	flagNoAuth
+	// flagBlocking commands may wait for other clients, so their run time is
+	// not reported to the latency monitor.
+	flagBlocking
+)
+
+var flagNames = []struct {
+	flag int
+	name string
+}{
+	{flagReadonly, "readonly"},
+	{flagWrite, "write"},
+	{flagAdmin, "admin"},
+	{flagNoAuth, "no_auth"},
+	{flagBlocking, "blocking"},
+}
+
+type command struct {
+	name string
+	// arity follows the Redis convention: it counts the command name itself,
+	// and a negative arity -N means "at least N".
+	arity int
+	flags int
+	// firstKey, lastKey and step give the positions of key arguments; a
+	// negative lastKey counts from the end. All zero means no keys.
+	firstKey int
+	lastKey  int
+	step     int
+	// subcommandKeys maps the subcommands that take a key, upper-cased, to
+	// its position, for commands whose key depends on the subcommand.
+	subcommandKeys map[string]int
+	summary        string
+	handler        func(srv *Server, c *Client, args []string)
+}
+
+func (cmd *command) flagList() []string {
+	var names []string
+	for _, f := range flagNames {
+		if cmd.flags&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// keys returns the key arguments of a call to cmd.
+func (cmd *command) keys(args []string) []string {
+	if cmd.subcommandKeys != nil && len(args) > 1 {
+		if i, ok := cmd.subcommandKeys[strings.ToUpper(args[1])]; ok && i < len(args) {
+			return args[i : i+1]
+		}
+		return nil
+	}
+	if cmd.firstKey == 0 {
+		return nil
+	}
+	last := cmd.lastKey
+	if last < 0 {
+		last += len(args)
+	}
+	var keys []string
+	for i := cmd.firstKey; i <= last && i < len(args); i += cmd.step {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+func (cmd *command) checkArity(n int) bool {
+	if cmd.arity < 0 {
+		return n >= -cmd.arity
+	}
+	return n == cmd.arity
+}
+
+// commandTable maps upper-cased command names to their definitions. It is
+// filled in init because some handlers refer back to the table.
+var commandTable map[string]*command
+
+func init() {
+	commands := []*command{
+		{name: "ping", arity: -1, flags: flagNoAuth,
+			summary: "Returns PONG, or the given message", handler: handlePing},
+		{name: "set", arity: -3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Sets the string value of a key, optionally with a TTL", handler: handleSet},
+		{name: "get", arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the string value of a key", handler: handleGet},
+		{name: "getex", arity: -2, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the string value of a key after setting or removing its expiry", handler: handleGetEx},
+		{name: "incr", arity: 2, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Increments the integer value of a key by one", handler: handleIncr},
+		{name: "decr", arity: 2, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Decrements the integer value of a key by one", handler: handleDecr},
+		{name: "incrby", arity: 3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Increments the integer value of a key by a number", handler: handleIncrBy},
+		{name: "decrby", arity: 3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Decrements the integer value of a key by a number", handler: handleDecrBy},
+		{name: "incrbyfloat", arity: 3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Increments the float value of a key by a number", handler: handleIncrByFloat},
+		{name: "cas", arity: 4, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Sets the string value of a key if it equals an expected value", handler: handleCAS},
+		{name: "cad", arity: 3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Deletes a key if its string value equals an expected value", handler: handleCAD},
+		{name: "setmax", arity: 3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Sets a key to an integer if it is greater than the current value", handler: handleSetMax},
+		{name: "setmaxfloat", arity: 3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Sets a key to a float if it is greater than the current value", handler: handleSetMaxFloat},
+		{name: "lcs", arity: -3, flags: flagReadonly, firstKey: 1, lastKey: 2, step: 1,
+			summary: "Finds the longest common subsequence of two strings", handler: handleLCS},
+		{name: "hset", arity: -4, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Sets the values of one or more fields in a hash", handler: handleHSet},
+		{name: "hget", arity: 3, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the value of a field in a hash", handler: handleHGet},
+		{name: "hdel", arity: -3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Deletes one or more fields from a hash", handler: handleHDel},
+		{name: "hgetall", arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns all fields and values of a hash", handler: handleHGetAll},
+		{name: "hexists", arity: 3, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Determines whether a field exists in a hash", handler: handleHExists},
+		{name: "hlen", arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the number of fields in a hash", handler: handleHLen},
+		{name: "lpush", arity: -3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Prepends one or more elements to a list", handler: handleLPush},
+		{name: "rpush", arity: -3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Appends one or more elements to a list", handler: handleRPush},
+		{name: "lpop", arity: -2, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Removes and returns the first elements of a list", handler: handleLPop},
+		{name: "rpop", arity: -2, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Removes and returns the last elements of a list", handler: handleRPop},
+		{name: "lrange", arity: 4, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns a range of elements from a list", handler: handleLRange},
+		{name: "llen", arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the length of a list", handler: handleLLen},
+		{name: "sadd", arity: -3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Adds one or more members to a set", handler: handleSAdd},
+		{name: "srem", arity: -3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Removes one or more members from a set", handler: handleSRem},
+		{name: "smembers", arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns all members of a set", handler: handleSMembers},
+		{name: "sismember", arity: 3, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Determines whether a member belongs to a set", handler: handleSIsMember},
+		{name: "scard", arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the number of members in a set", handler: handleSCard},
+		{name: "sinter", arity: -2, flags: flagReadonly, firstKey: 1, lastKey: -1, step: 1,
+			summary: "Returns the intersection of multiple sets", handler: handleSInter},
+		{name: "sunion", arity: -2, flags: flagReadonly, firstKey: 1, lastKey: -1, step: 1,
+			summary: "Returns the union of multiple sets", handler: handleSUnion},
+		{name: "sdiff", arity: -2, flags: flagReadonly, firstKey: 1, lastKey: -1, step: 1,
+			summary: "Returns the difference of the first set and the others", handler: handleSDiff},
+		{name: "del", arity: 2, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Deletes a key", handler: handleDel},
+		{name: "exists", arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Determines whether a key exists", handler: handleExists},
+		{name: "persist", arity: 2, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Removes the expiration time of a key", handler: handlePersist},
+		{name: "flushall", arity: -1, flags: flagWrite,
+			summary: "Removes all keys", handler: handleFlushAll},
+		{name: "keys", arity: -2, flags: flagReadonly,
+			summary: "Returns key names that match a pattern, up to an optional limit", handler: handleKeys},
+		{name: "randomkey", arity: -1, flags: flagReadonly,
+			summary: "Returns a random key name, optionally of a given type", handler: handleRandomKey},
+		{name: "rename", arity: 3, flags: flagWrite, firstKey: 1, lastKey: 2, step: 1,
+			summary: "Renames a key, overwriting the destination", handler: handleRename},
+		{name: "ttl", arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the remaining time to live of a key in seconds", handler: handleTTL},
+		{name: "expiretime", arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the expiration time of a key as a Unix timestamp", handler: handleExpireTime},
+		{name: "pexpiretime", arity: 2, flags: flagReadonly, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Returns the expiration time of a key as a Unix milliseconds timestamp", handler: handlePExpireTime},
+		{name: "waitkey", arity: 3, flags: flagReadonly | flagBlocking, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Blocks until a key exists or a timeout elapses", handler: handleWaitKey},
+		{name: "expire", arity: 3, flags: flagWrite, firstKey: 1, lastKey: 1, step: 1,
+			summary: "Sets the time to live of a key in seconds", handler: handleExpire},
+		{name: "client", arity: -2,
+			summary: "Inspects and manages client connections", handler: handleClient},
+		{name: "command", arity: -1,
+			summary: "Returns information about commands", handler: handleCommand},
+		{name: "echo", arity: 2,
+			summary: "Returns the given string", handler: handleEcho},
+		{name: "lolwut", arity: -1, flags: flagReadonly,
+			summary: "Displays the cask banner and version", handler: handleLolwut},
+		{name: "object", arity: -2, flags: flagReadonly, subcommandKeys: map[string]int{"ENCODING": 2},
+			summary: "Inspects the internals of a key's value", handler: handleObject},
+		{name: "memory", arity: -2, flags: flagReadonly, subcommandKeys: map[string]int{"USAGE": 2},
+			summary: "Reports memory usage of keys and the server", handler: handleMemory},
+		{name: "debug", arity: -2, flags: flagAdmin, subcommandKeys: map[string]int{"OBJECT": 2},
+			summary: "Low-level commands for debugging the server", handler: handleDebug},
+		{name: "info", arity: -1,
+			summary: "Returns information and statistics about the server", handler: handleInfo},
+		{name: "latency", arity: -2, flags: flagAdmin,
+			summary: "Reports latency spikes recorded by the latency monitor", handler: handleLatency},
+		{name: "acl", arity: -2, flags: flagAdmin,
+			summary: "Manages ACL users", handler: handleACL},
+		{name: "auth", arity: -2, flags: flagNoAuth,
+			summary: "Authenticates the connection as an ACL user", handler: handleAuth},
+		{name: "role", arity: 1, flags: flagAdmin,
+			summary: "Returns the replication role of the server", handler: handleRole},
+		{name: "shutdown", arity: -1, flags: flagAdmin,
+			summary: "Stops the server", handler: handleShutdown},
+		{name: "cluster", arity: -2,
+			summary: "Reports cluster state for cluster-aware clients", handler: handleCluster},
+		{name: "hello", arity: -1, flags: flagNoAuth,
+			summary: "Handshakes with the server, optionally authenticating", handler: handleHello},
+		{name: "quit", arity: 1, flags: flagNoAuth,
+			summary: "Closes the connection", handler: handleQuit},
+		{name: "reset", arity: 1, flags: flagNoAuth,
+			summary: "Resets the connection's state", handler: handleReset},
+	}
+
+	commandTable = make(map[string]*command, len(commands))
+	for _, cmd := range commands {
+		commandTable[strings.ToUpper(cmd.name)] = cmd
+	}
+}
+
+func lookupCommand(name string) (*command, bool) {
+	cmd, ok := commandTable[strings.ToUpper(name)]
+	return cmd, ok
+}
+
+func handlePing(srv *Server, c *Client, args []string) {
+	switch len(args) {
+	case 1:
+		c.writeSimple("PONG")
+	case 2:
+		c.writeBulk(args[1])
+	default:
+		c.writeError("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+// handleRole always reports a master: cask has no replication, so there is
+// never an offset or a replica to list.
+func handleRole(srv *Server, c *Client, args []string) {
+	c.writeArrayLen(3)
+	c.writeBulk("master")
+	c.writeInt(0)
+	c.writeArrayLen(0)
+}
+
+func handleEcho(srv *Server, c *Client, args []string) {
+	c.writeBulk(args[1])
+}
+
+const lolwutBanner = `  ___ __ _ ___| | __
+ / __/ _` + "`" + ` / __| |/ /
+| (_| (_| \__ \   <
+ \___\__,_|___/_|\_\
+`
+
+func handleLolwut(srv *Server, c *Client, args []string) {
+	c.writeBulk(lolwutBanner + "\ncask ver. " + Version + "\n")
+}
+
+func handleSet(srv *Server, c *Client, args []string) {
+	var opts SetOptions
+	expiryOption := ""
+	for i := 3; i < len(args); i++ {
+		option := strings.ToUpper(args[i])
+		switch option {
+		case "EX", "PX", "EXAT", "PXAT":
+			if expiryOption != "" || i+1 == len(args) {
+				c.writeError("ERR syntax error")
+				return
+			}
+			expiryOption = option
+			i++
+			var ok bool
+			opts.ExpiresAt, ok = srv.expiryFromOption(option, args[i])
+			if !ok {
+				c.writeError("ERR invalid expire time in 'set' command")
+				return
+			}
+		case "NX":
+			if opts.IfExists {
+				c.writeError("ERR syntax error")
+				return
+			}
+			opts.IfMissing = true
+		case "XX":
+			if opts.IfMissing {
+				c.writeError("ERR syntax error")
+				return
+			}
+			opts.IfExists = true
+		case "GET":
+			opts.Get = true
+		default:
+			c.writeError("ERR syntax error")
+			return
+		}
+	}
+	if srv.valueTooLarge(len(args[2])) {
+		c.writeError("ERR value exceeds maximum allowed size")
+		return
+	}
+	result, err := srv.store.Set(args[1], args[2], opts)
+	switch {
+	case err != nil:
+		c.writeError(err.Error())
+	case opts.Get && result.OldFound:
+		c.writeBulk(result.Old)
+	case opts.Get || !result.Written:
+		c.writeNil()
+	default:
+		c.writeSimple("OK")
+	}
+}
+
+func handleGet(srv *Server, c *Client, args []string) {
+	val, ok, err := srv.store.Get(args[1])
+	if err != nil {
+		c.writeError(err.Error())
+	} else if ok {
+		c.writeBulk(val)
+	} else {
+		c.writeNil()
+	}
+}
+
+// expiryFromOption turns the argument of an EX, PX, EXAT or PXAT option into
+// an absolute deadline. It rejects non-positive values and ones that would
+// overflow a time.Duration.
+//
+// TTL validation follows Redis. Commands that create or read a key with an
+// expiry option (SET, GETEX) reject a TTL of 0 or less. EXPIRE instead treats
+// a non-positive TTL as a deadline already passed and deletes the key.
+//
+// Relative TTLs (EX, PX) are jittered per Config.ExpireJitter; absolute ones
+// are taken as given.
+func (srv *Server) expiryFromOption(option, arg string) (time.Time, bool) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}, false
+	}
+	switch option {
+	case "EX":
+		if n > math.MaxInt64/int64(time.Second) {
+			return time.Time{}, false
+		}
+		return time.Now().Add(srv.jitterTTL(time.Duration(n) * time.Second)), true
+	case "PX":
+		if n > math.MaxInt64/int64(time.Millisecond) {
+			return time.Time{}, false
+		}
+		return time.Now().Add(srv.jitterTTL(time.Duration(n) * time.Millisecond)), true
+	case "EXAT":
+		return time.Unix(n, 0), true
+	case "PXAT":
+		return time.UnixMilli(n), true
+	}
+	return time.Time{}, false
+}
+
+func handleGetEx(srv *Server, c *Client, args []string) {
+	var expiresAt time.Time
+	persist := false
+	if len(args) > 2 {
+		option := strings.ToUpper(args[2])
+		switch {
+		case option == "PERSIST" && len(args) == 3:
+			persist = true
+		case (option == "EX" || option == "PX" || option == "EXAT" || option == "PXAT") && len(args) == 4:
+			var ok bool
+			expiresAt, ok = srv.expiryFromOption(option, args[3])
+			if !ok {
+				c.writeError("ERR invalid expire time in 'getex' command")
+				return
+			}
+		default:
+			c.writeError("ERR syntax error")
+			return
+		}
+	}
+
+	val, ok, err := srv.store.GetEx(args[1], expiresAt, persist)
+	if err != nil {
+		c.writeError(err.Error())
+	} else if ok {
+		c.writeBulk(val)
+	} else {
+		c.writeNil()
+	}
+}
+
+func handleIncr(srv *Server, c *Client, args []string) {
+	incrBy(srv, c, args[1], 1)
+}
+
+func handleDecr(srv *Server, c *Client, args []string) {
+	incrBy(srv, c, args[1], -1)
+}
+
+func handleIncrBy(srv *Server, c *Client, args []string) {
+	delta, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		c.writeError(ErrNotInt.Error())
+		return
+	}
+	incrBy(srv, c, args[1], delta)
+}
+
+func handleDecrBy(srv *Server, c *Client, args []string) {
+	delta, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		c.writeError(ErrNotInt.Error())
+		return
+	}
+	if delta == math.MinInt64 {
+		c.writeError("ERR decrement would overflow")
+		return
+	}
+	incrBy(srv, c, args[1], -delta)
+}
+
+func incrBy(srv *Server, c *Client, key string, delta int64) {
+	n, err := srv.store.IncrBy(key, delta)
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeInt(n)
+}
+
+func handleIncrByFloat(srv *Server, c *Client, args []string) {
+	incr, ok := parseFloat(args[2])
+	if !ok {
+		c.writeError(ErrNotFloat.Error())
+		return
+	}
+	val, err := srv.store.IncrByFloat(args[1], incr)
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeBulk(val)
+}
+
+func handleCAS(srv *Server, c *Client, args []string) {
+	if srv.valueTooLarge(len(args[3])) {
+		c.writeError("ERR value exceeds maximum allowed size")
+		return
+	}
+	swapped, err := srv.store.CompareAndSet(args[1], args[2], args[3])
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeBool(swapped)
+}
+
+func handleCAD(srv *Server, c *Client, args []string) {
+	deleted, err := srv.store.CompareAndDelete(args[1], args[2])
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeBool(deleted)
+}
+
+func handleSetMax(srv *Server, c *Client, args []string) {
+	n, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		c.writeError(ErrNotInt.Error())
+		return
+	}
+	val, err := srv.store.SetMax(args[1], n)
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeInt(val)
+}
+
+func handleSetMaxFloat(srv *Server, c *Client, args []string) {
+	f, ok := parseFloat(args[2])
+	if !ok {
+		c.writeError(ErrNotFloat.Error())
+		return
+	}
+	val, err := srv.store.SetMaxFloat(args[1], f)
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeBulk(val)
+}
+
+func handleDel(srv *Server, c *Client, args []string) {
+	c.writeBool(srv.store.Del(args[1]))
+}
+
+func handleExists(srv *Server, c *Client, args []string) {
+	c.writeBool(srv.store.Exists(args[1]))
+}
+
+func handlePersist(srv *Server, c *Client, args []string) {
+	c.writeBool(srv.store.Persist(args[1]))
+}
+
+func handleFlushAll(srv *Server, c *Client, args []string) {
+	srv.store.FlushAll()
+	c.writeSimple("OK")
+}
+
+func handleKeys(srv *Server, c *Client, args []string) {
+	limit := 0
+	if len(args) > 2 {
+		if len(args) != 4 || strings.ToUpper(args[2]) != "LIMIT" {
+			c.writeError("ERR syntax error")
+			return
+		}
+		n, err := strconv.Atoi(args[3])
+		if err != nil || n <= 0 {
+			c.writeError("ERR LIMIT must be a positive integer")
+			return
+		}
+		limit = n
+	}
+	c.writeBulkArray(srv.store.Keys(args[1], limit))
+}
+
+func handleRandomKey(srv *Server, c *Client, args []string) {
+	typ := ""
+	switch {
+	case len(args) == 1:
+	case len(args) == 3 && strings.ToUpper(args[1]) == "TYPE":
+		typ = strings.ToLower(args[2])
+	default:
+		c.writeError("ERR syntax error")
+		return
+	}
+	key, ok := srv.store.RandomKey(typ)
+	if !ok {
+		c.writeNil()
+		return
+	}
+	c.writeBulk(key)
+}
+
+func handleRename(srv *Server, c *Client, args []string) {
+	if !srv.store.Rename(args[1], args[2]) {
+		c.writeError("ERR no such key")
+		return
+	}
+	c.writeSimple("OK")
+}
+
+func handleTTL(srv *Server, c *Client, args []string) {
+	c.writeInt(int64(srv.store.TTL(args[1])))
+}
+
+func handleExpireTime(srv *Server, c *Client, args []string) {
+	expiresAt, status := srv.store.ExpireTime(args[1])
+	if status != 0 {
+		c.writeInt(int64(status))
+		return
+	}
+	c.writeInt(expiresAt.Unix())
+}
+
+func handlePExpireTime(srv *Server, c *Client, args []string) {
+	expiresAt, status := srv.store.ExpireTime(args[1])
+	if status != 0 {
+		c.writeInt(int64(status))
+		return
+	}
+	c.writeInt(expiresAt.UnixMilli())
+}
+
+func handleWaitKey(srv *Server, c *Client, args []string) {
+	seconds, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) || seconds > math.MaxInt64/float64(time.Second) {
+		c.writeError("ERR timeout is not a float or out of range")
+		return
+	}
+	if seconds < 0 {
+		c.writeError("ERR timeout is negative")
+		return
+	}
+
+	exists, set, cancel := srv.store.WaitKey(args[1])
+	if exists {
+		c.writeInt(1)
+		return
+	}
+	defer cancel()
+
+	// Nothing reads the connection while the command blocks, so watch it
+	// for the peer going away, which otherwise would leave the waiter in
+	// place until the key is set.
+	closed, stop := c.watchClose()
+	defer stop()
+
+	// A zero timeout blocks until the key is set or the client is killed.
+	var timeout <-chan time.Time
+	if seconds > 0 {
+		timer := time.NewTimer(time.Duration(seconds * float64(time.Second)))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-set:
+		c.writeInt(1)
+	case <-timeout:
+		c.writeInt(0)
+	case <-c.killed:
+	case <-closed:
+	}
+}
+
+func handleExpire(srv *Server, c *Client, args []string) {
+	seconds, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		c.writeError(ErrNotInt.Error())
+		return
+	}
+	if seconds > math.MaxInt64/int64(time.Second) || seconds < math.MinInt64/int64(time.Second) {
+		c.writeError("ERR invalid expire time in 'expire' command")
+		return
+	}
+	c.writeBool(srv.store.Expire(args[1], time.Now().Add(srv.jitterTTL(time.Duration(seconds)*time.Second))))
+}
+
+// handleShutdown closes the server from a goroutine of its own, since Close
+// waits for every connection handler, this one included, to return.
+func handleShutdown(srv *Server, c *Client, args []string) {
+	if len(args) > 2 {
+		c.writeError("ERR syntax error")
+		return
+	}
+	if len(args) == 2 {
+		switch strings.ToUpper(args[1]) {
+		case "NOSAVE":
+		case "SAVE":
+			c.writeError("ERR Errors trying to SHUTDOWN. Persistence is not supported.")
+			return
+		default:
+			c.writeError("ERR syntax error")
+			return
+		}
+	}
+	srv.config.Logger.Printf("User requested shutdown from %s", c.addr)
+	go srv.Close()
+}
+
+func handleQuit(srv *Server, c *Client, args []string) {
+	c.quit = true
+	c.writeSimple("OK")
+}
+
+func handleReset(srv *Server, c *Client, args []string) {
+	c.Reset(!srv.acl.AuthRequired())
+	c.writeSimple("RESET")
+}
+
+func sortedCommands() []*command {
+	commands := make([]*command, 0, len(commandTable))
+	for _, cmd := range commandTable {
+		commands = append(commands, cmd)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].name < commands[j].name })
+	return commands
+}
+
+func (c *Client) writeCommandInfo(cmd *command) {
+	c.writeArrayLen(6)
+	c.writeBulk(cmd.name)
+	c.writeInt(int64(cmd.arity))
+	flags := cmd.flagList()
+	c.writeArrayLen(len(flags))
+	for _, flag := range flags {
+		c.writeSimple(flag)
+	}
+	c.writeInt(int64(cmd.firstKey))
+	c.writeInt(int64(cmd.lastKey))
+	c.writeInt(int64(cmd.step))
+}
+
+func (c *Client) writeCommandDocs(cmd *command) {
+	c.writeBulk(cmd.name)
+	c.writeMapLen(1)
+	c.writeBulk("summary")
+	c.writeBulk(cmd.summary)
+}
+
+var commandHelp = []string{
+	"(no subcommand)",
+	"    Return details about all commands.",
+	"COUNT",
+	"    Return the total number of commands.",
+	"INFO [<command-name> ...]",
+	"    Return details about the given commands, or all commands if none are given.",
+	"DOCS [<command-name> ...]",
+	"    Return documentation for the given commands, or all commands if none are given.",
+}
+
+func handleCommand(srv *Server, c *Client, args []string) {
+	if len(args) == 1 {
+		commands := sortedCommands()
+		c.writeArrayLen(len(commands))
+		for _, cmd := range commands {
+			c.writeCommandInfo(cmd)
+		}
+		return
+	}
+
+	switch strings.ToUpper(args[1]) {
+	case "HELP":
+		c.writeHelp("COMMAND", commandHelp)
+	case "COUNT":
+		c.writeInt(int64(len(commandTable)))
+	case "INFO":
+		names := args[2:]
+		if len(names) == 0 {
+			for _, cmd := range sortedCommands() {
+				names = append(names, cmd.name)
+			}
+		}
+		c.writeArrayLen(len(names))
+		for _, name := range names {
+			if cmd, ok := lookupCommand(name); ok {
+				c.writeCommandInfo(cmd)
+			} else {
+				c.writeNilArray()
+			}
+		}
+	case "DOCS":
+		var commands []*command
+		if len(args) == 2 {
+			commands = sortedCommands()
+		} else {
+			for _, name := range args[2:] {
+				if cmd, ok := lookupCommand(name); ok {
+					commands = append(commands, cmd)
+				}
+			}
+		}
+		c.writeMapLen(len(commands))
+		for _, cmd := range commands {
+			c.writeCommandDocs(cmd)
+		}
+	default:
+		c.writeUnknownSubcommand("COMMAND", args[1])
+	}
+}
+
+// maxErrorArgsLen caps how much of the name and arguments an unknown command
+// error quotes back, as Redis does.
+const maxErrorArgsLen = 128
+
+// unknownCommandError quotes the command name and the first of its arguments,
+// in Redis's format.
+func unknownCommandError(args []string) string {
+	var quoted strings.Builder
+	for _, arg := range args[1:] {
+		if quoted.Len() >= maxErrorArgsLen {
+			break
+		}
+		fmt.Fprintf(&quoted, "'%s' ", truncate(arg, maxErrorArgsLen-quoted.Len()))
+	}
+	return fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", truncate(args[0], maxErrorArgsLen), quoted.String())
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
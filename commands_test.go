@@ -0,0 +1,242 @@
+package cask
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testClient runs commands on a server in-process and returns the raw reply
+// bytes, so tests can check the exact RESP encoding.
+type testClient struct {
+	srv *Server
+	c   *Client
+	out bytes.Buffer
+}
+
+func newTestClient(t *testing.T, srv *Server) *testClient {
+	t.Helper()
+	conn, peer := net.Pipe()
+	t.Cleanup(func() { conn.Close(); peer.Close() })
+	tc := &testClient{srv: srv}
+	tc.c = srv.registerClient(conn)
+	tc.c.w = bufio.NewWriter(&tc.out)
+	return tc
+}
+
+func (tc *testClient) do(args ...string) string {
+	tc.srv.dispatch(tc.c, args)
+	tc.c.flush()
+	reply := tc.out.String()
+	tc.out.Reset()
+	return reply
+}
+
+// run sends each command in script, "|"-separated arguments, and checks its
+// raw reply.
+func run(t *testing.T, tc *testClient, script [][2]string) {
+	t.Helper()
+	for _, step := range script {
+		if got := tc.do(strings.Split(step[0], "|")...); got != step[1] {
+			t.Errorf("%s: reply %q, want %q", step[0], got, step[1])
+		}
+	}
+}
+
+func TestSetConditionalReplies(t *testing.T) {
+	srv := newTestServer(t, Config{})
+	tc := newTestClient(t, srv)
+	run(t, tc, [][2]string{
+		{"SET|k|v1|NX", "+OK\r\n"},
+		{"SET|k|v2|NX", "$-1\r\n"},
+		{"GET|k", "$2\r\nv1\r\n"},
+		{"SET|missing|v|XX", "$-1\r\n"},
+		{"EXISTS|missing", ":0\r\n"},
+		{"SET|k|v3|XX", "+OK\r\n"},
+		{"SET|k|v4|GET", "$2\r\nv3\r\n"},
+		{"SET|new|v|GET", "$-1\r\n"},
+		{"GET|new", "$1\r\nv\r\n"},
+		{"SET|k|v5|NX|GET", "$2\r\nv4\r\n"},
+		{"GET|k", "$2\r\nv4\r\n"},
+		{"SET|k|v6|XX|GET", "$2\r\nv4\r\n"},
+		{"SET|missing|v|XX|GET", "$-1\r\n"},
+		{"EXISTS|missing", ":0\r\n"},
+		{"SET|k|v|NX|XX", "-ERR syntax error\r\n"},
+		{"HSET|h|f|v", ":1\r\n"},
+		{"SET|h|v|GET", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	})
+}
+
+func TestSetConditionalRepliesRESP3(t *testing.T) {
+	srv := newTestServer(t, Config{})
+	tc := newTestClient(t, srv)
+	tc.do("HELLO", "3")
+	run(t, tc, [][2]string{
+		{"SET|k|v1|NX", "+OK\r\n"},
+		{"SET|k|v2|NX", "_\r\n"},
+		{"SET|missing|v|XX", "_\r\n"},
+		{"SET|k|v3|GET", "$2\r\nv1\r\n"},
+		{"SET|new|v|GET", "_\r\n"},
+		{"GET|missing", "_\r\n"},
+	})
+}
+
+// TestEmptyCollectionsAreDeleted checks that removing the last element of a
+// hash, list or set deletes its key.
+func TestEmptyCollectionsAreDeleted(t *testing.T) {
+	srv := newTestServer(t, Config{})
+	tc := newTestClient(t, srv)
+	run(t, tc, [][2]string{
+		{"HSET|h|f1|v|f2|v", ":2\r\n"},
+		{"HDEL|h|f1", ":1\r\n"},
+		{"EXISTS|h", ":1\r\n"},
+		{"HDEL|h|f2|missing", ":1\r\n"},
+		{"EXISTS|h", ":0\r\n"},
+
+		{"RPUSH|l|a|b|c", ":3\r\n"},
+		{"LPOP|l", "$1\r\na\r\n"},
+		{"EXISTS|l", ":1\r\n"},
+		{"RPOP|l|5", "*2\r\n$1\r\nc\r\n$1\r\nb\r\n"},
+		{"EXISTS|l", ":0\r\n"},
+
+		{"SADD|s|m1|m2", ":2\r\n"},
+		{"SREM|s|m1", ":1\r\n"},
+		{"EXISTS|s", ":1\r\n"},
+		{"SREM|s|m2|missing", ":1\r\n"},
+		{"EXISTS|s", ":0\r\n"},
+
+		{"SET|str|v", "+OK\r\n"},
+		{"HDEL|str|f", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"LPOP|str", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"SREM|str|m", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"EXISTS|str", ":1\r\n"},
+	})
+}
+
+// TestWrongTypeMatrix runs every typed command against a key of each type it
+// doesn't operate on and checks for WRONGTYPE with the key left unchanged.
+func TestWrongTypeMatrix(t *testing.T) {
+	const wrongType = "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+	keyTypes := []struct {
+		name   string
+		family string
+		setup  string
+		check  string
+		want   string
+	}{
+		{"string", "string", "SET|k|hello", "GET|k", "$5\r\nhello\r\n"},
+		{"int", "string", "SET|k|10", "GET|k", "$2\r\n10\r\n"},
+		{"hash", "hash", "HSET|k|f|v", "HGETALL|k", "*2\r\n$1\r\nf\r\n$1\r\nv\r\n"},
+		{"list", "list", "RPUSH|k|a", "LRANGE|k|0|-1", "*1\r\n$1\r\na\r\n"},
+		{"set", "set", "SADD|k|m", "SMEMBERS|k", "*1\r\n$1\r\nm\r\n"},
+	}
+	commands := map[string][]string{
+		"string": {
+			"GET|k", "GETEX|k|PERSIST", "SET|k|v|GET", "INCR|k", "DECR|k",
+			"INCRBY|k|1", "DECRBY|k|1", "INCRBYFLOAT|k|1.5", "CAS|k|hello|new",
+			"CAD|k|hello", "SETMAX|k|100", "SETMAXFLOAT|k|100", "LCS|k|other", "LCS|other|k",
+		},
+		"hash": {"HSET|k|f2|v2", "HGET|k|f", "HDEL|k|f", "HGETALL|k", "HEXISTS|k|f", "HLEN|k"},
+		"list": {"LPUSH|k|x", "RPUSH|k|x", "LPOP|k", "RPOP|k|2", "LRANGE|k|0|-1", "LLEN|k"},
+		"set": {
+			"SADD|k|x", "SREM|k|m", "SMEMBERS|k", "SISMEMBER|k|m", "SCARD|k",
+			"SINTER|other|k", "SUNION|other|k", "SDIFF|k|other",
+		},
+	}
+
+	for _, kt := range keyTypes {
+		for family, cmds := range commands {
+			if family == kt.family {
+				continue
+			}
+			for _, cmd := range cmds {
+				t.Run(kt.name+"/"+cmd, func(t *testing.T) {
+					tc := newTestClient(t, newTestServer(t, Config{}))
+					tc.do(strings.Split(kt.setup, "|")...)
+					run(t, tc, [][2]string{
+						{cmd, wrongType},
+						{kt.check, kt.want},
+					})
+				})
+			}
+		}
+	}
+}
+
+// TestIncrOverflow checks the int64 boundaries of the INCR family: an
+// operation that would overflow is rejected and leaves the value alone.
+func TestIncrOverflow(t *testing.T) {
+	const overflow = "-ERR increment or decrement would overflow\r\n"
+	tests := []struct {
+		start string
+		cmd   string
+		reply string
+	}{
+		{"9223372036854775806", "INCR|k", ":9223372036854775807\r\n"},
+		{"9223372036854775807", "INCR|k", overflow},
+		{"-9223372036854775807", "DECR|k", ":-9223372036854775808\r\n"},
+		{"-9223372036854775808", "DECR|k", overflow},
+		{"9223372036854775807", "INCRBY|k|1", overflow},
+		{"9223372036854775807", "INCRBY|k|-1", ":9223372036854775806\r\n"},
+		{"-10", "INCRBY|k|-9223372036854775798", ":-9223372036854775808\r\n"},
+		{"-10", "INCRBY|k|-9223372036854775800", overflow},
+		{"-9223372036854775808", "INCRBY|k|9223372036854775807", ":-1\r\n"},
+		{"5", "DECRBY|k|-9223372036854775808", "-ERR decrement would overflow\r\n"},
+		{"-2", "DECRBY|k|9223372036854775807", overflow},
+		{"5", "DECRBY|k|-9223372036854775807", overflow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.start+" "+tt.cmd, func(t *testing.T) {
+			tc := newTestClient(t, newTestServer(t, Config{}))
+			tc.do("SET", "k", tt.start)
+			want := "$" + strconv.Itoa(len(tt.start)) + "\r\n" + tt.start + "\r\n"
+			if tt.reply[0] == ':' {
+				want = "$" + strconv.Itoa(len(tt.reply)-3) + "\r\n" + tt.reply[1:]
+			}
+			run(t, tc, [][2]string{
+				{tt.cmd, tt.reply},
+				{"GET|k", want},
+			})
+		})
+	}
+}
+
+func TestExpireTimeValidation(t *testing.T) {
+	const (
+		invalidSet   = "-ERR invalid expire time in 'set' command\r\n"
+		invalidGetEx = "-ERR invalid expire time in 'getex' command\r\n"
+	)
+	srv := newTestServer(t, Config{})
+	tc := newTestClient(t, srv)
+	run(t, tc, [][2]string{
+		{"SET|k|v|EX|0", invalidSet},
+		{"SET|k|v|PX|-1", invalidSet},
+		{"SET|k|v|EXAT|0", invalidSet},
+		{"SET|k|v|PXAT|-5", invalidSet},
+		{"SET|k|v|EX|9223372036854775807", invalidSet},
+		{"SET|k|v|PX|9223372036854775807", invalidSet},
+		{"SET|k|v|EX|9223372037", invalidSet},
+		{"EXISTS|k", ":0\r\n"},
+
+		{"SET|k|v", "+OK\r\n"},
+		{"GETEX|k|EX|0", invalidGetEx},
+		{"GETEX|k|PX|-1", invalidGetEx},
+		{"GETEX|k|EX|9223372036854775807", invalidGetEx},
+		{"TTL|k", ":-1\r\n"},
+
+		{"SET|big|v|EX|9223372036", "+OK\r\n"},
+		{"EXISTS|big", ":1\r\n"},
+
+		{"EXPIRE|k|9223372036854775807", "-ERR invalid expire time in 'expire' command\r\n"},
+		{"EXISTS|k", ":1\r\n"},
+		{"EXPIRE|k|0", ":1\r\n"},
+		{"EXISTS|k", ":0\r\n"},
+		{"SET|k|v", "+OK\r\n"},
+		{"EXPIRE|k|-5", ":1\r\n"},
+		{"EXISTS|k", ":0\r\n"},
+		{"EXPIRE|k|-5", ":0\r\n"},
+	})
+}
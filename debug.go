@@ -0,0 +1,118 @@
+package cask
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// serializedLength approximates how many bytes the value takes when written
+// out, which for strings is simply their length.
+func serializedLength(v Value) int {
+	switch v := v.(type) {
+	case StringValue:
+		return len(v)
+	case IntValue:
+		return len(v.String())
+	case HashValue:
+		n := 0
+		for field, value := range v {
+			n += len(field) + len(value)
+		}
+		return n
+	case *ListValue:
+		n := 0
+		for i := 0; i < v.Len(); i++ {
+			n += len(v.At(i))
+		}
+		return n
+	case SetValue:
+		n := 0
+		for member := range v {
+			n += len(member)
+		}
+		return n
+	}
+	return 0
+}
+
+func describeValue(v Value) string {
+	desc := fmt.Sprintf("type:%s encoding:%s serializedlength:%d", v.Type(), v.Encoding(), serializedLength(v))
+	if coll, ok := v.(collection); ok {
+		desc += fmt.Sprintf(" elements:%d", coll.Len())
+	}
+	return desc
+}
+
+var debugHelp = []string{
+	"OBJECT <key>",
+	"    Show low-level information about the key's value.",
+	"SET-RANDOM-SEED <seed>",
+	"    Seed the random source used by RANDOMKEY and TTL jitter, for deterministic tests.",
+	"SET-SORTED-KEYS <0|1>",
+	"    Return KEYS results in lexicographic order, for deterministic tests.",
+}
+
+func handleDebug(srv *Server, c *Client, args []string) {
+	switch strings.ToUpper(args[1]) {
+	case "HELP":
+		c.writeHelp("DEBUG", debugHelp)
+	case "OBJECT":
+		if len(args) != 3 {
+			c.writeError("ERR wrong number of arguments for 'debug|object' command")
+			return
+		}
+		desc, ok := srv.store.DebugObject(args[2])
+		if !ok {
+			c.writeError("ERR no such key")
+			return
+		}
+		c.writeSimple(desc)
+	case "SET-RANDOM-SEED":
+		if len(args) != 3 {
+			c.writeError("ERR wrong number of arguments for 'debug|set-random-seed' command")
+			return
+		}
+		seed, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			c.writeError(ErrNotInt.Error())
+			return
+		}
+		srv.store.Seed(seed)
+		c.writeSimple("OK")
+	case "SET-SORTED-KEYS":
+		if len(args) != 3 || (args[2] != "0" && args[2] != "1") {
+			c.writeError("ERR DEBUG SET-SORTED-KEYS needs 0 or 1")
+			return
+		}
+		srv.store.SetSortedKeys(args[2] == "1")
+		c.writeSimple("OK")
+	default:
+		c.writeUnknownSubcommand("DEBUG", args[1])
+	}
+}
+
+var objectHelp = []string{
+	"ENCODING <key>",
+	"    Return the internal encoding of the key's value.",
+}
+
+func handleObject(srv *Server, c *Client, args []string) {
+	switch strings.ToUpper(args[1]) {
+	case "HELP":
+		c.writeHelp("OBJECT", objectHelp)
+	case "ENCODING":
+		if len(args) != 3 {
+			c.writeError("ERR wrong number of arguments for 'object|encoding' command")
+			return
+		}
+		encoding, ok := srv.store.ObjectEncoding(args[2])
+		if !ok {
+			c.writeNil()
+			return
+		}
+		c.writeBulk(encoding)
+	default:
+		c.writeUnknownSubcommand("OBJECT", args[1])
+	}
+}
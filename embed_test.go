@@ -0,0 +1,101 @@
+package cask_test
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olivemonk/cask"
+)
+
+// TestEmbeddedServer runs cask the way another program's tests would: on an
+// ephemeral port, driven over a real TCP connection, then shut down.
+func TestEmbeddedServer(t *testing.T) {
+	srv := cask.NewServer(cask.Config{Addr: "127.0.0.1:0"})
+	if err := srv.Listen(); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve() }()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	exchanges := []struct {
+		request string
+		reply   string
+	}{
+		{"*3\r\n$3\r\nSET\r\n$5\r\nhello\r\n$5\r\nworld\r\n", "+OK\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$5\r\nhello\r\n", "$5\r\nworld\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$7\r\nmissing\r\n", "$-1\r\n"},
+	}
+	for _, ex := range exchanges {
+		if _, err := io.WriteString(conn, ex.request); err != nil {
+			t.Fatalf("write %q: %v", ex.request, err)
+		}
+		reply := make([]byte, len(ex.reply))
+		if _, err := io.ReadFull(reader, reply); err != nil {
+			t.Fatalf("read reply to %q: %v", ex.request, err)
+		}
+		if string(reply) != ex.reply {
+			t.Errorf("reply to %q = %q, want %q", ex.request, reply, ex.reply)
+		}
+	}
+
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after Close")
+	}
+
+	// Close also disconnects clients, so the open connection sees EOF.
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("read after Close = %v, want EOF", err)
+	}
+	if _, err := net.Dial("tcp", srv.Addr().String()); err == nil {
+		t.Error("server still accepts connections after Close")
+	}
+}
+
+// TestEmbeddedServerLogger checks that the server's output goes to the
+// configured logger rather than to stdout or the standard logger.
+func TestEmbeddedServerLogger(t *testing.T) {
+	var buf bytes.Buffer
+	srv := cask.NewServer(cask.Config{Addr: "127.0.0.1:0", Logger: log.New(&buf, "cask: ", 0)})
+	if err := srv.Listen(); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go srv.Serve()
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	io.WriteString(conn, "*1\r\n$4\r\nPING\r\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := bufio.NewReader(conn).ReadString('\n'); err != nil {
+		t.Fatalf("read PING reply: %v", err)
+	}
+	conn.Close()
+	srv.Close()
+
+	if !strings.Contains(buf.String(), "cask: Client connected: ") {
+		t.Errorf("logger output = %q, want the client connection", buf.String())
+	}
+}
@@ -0,0 +1,146 @@
+package cask
+
+import (
+	"container/heap"
+	"time"
+)
+
+const (
+	// expireBatchSize bounds how many keys the reaper removes per lock hold.
+	expireBatchSize = 1000
+	// expireIdleWait is how long the reaper sleeps when no key has a TTL; a
+	// new TTL wakes it earlier.
+	expireIdleWait = time.Minute
+)
+
+type expiryItem struct {
+	key       string
+	expiresAt time.Time
+	index     int
+}
+
+// expiryHeap is a min-heap of keys ordered by expiry time.
+type expiryHeap []*expiryItem
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x any) {
+	item := x.(*expiryItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// expireIndex tracks every key that carries a TTL. items lets an overwritten
+// or persisted TTL be fixed up or removed in place.
+type expireIndex struct {
+	heap  expiryHeap
+	items map[string]*expiryItem
+}
+
+func newExpireIndex() *expireIndex {
+	return &expireIndex{items: make(map[string]*expiryItem)}
+}
+
+// set records key's expiry and reports whether it is now the earliest one.
+func (x *expireIndex) set(key string, expiresAt time.Time) bool {
+	if item, found := x.items[key]; found {
+		item.expiresAt = expiresAt
+		heap.Fix(&x.heap, item.index)
+		return item.index == 0
+	}
+	item := &expiryItem{key: key, expiresAt: expiresAt}
+	x.items[key] = item
+	heap.Push(&x.heap, item)
+	return item.index == 0
+}
+
+func (x *expireIndex) remove(key string) {
+	item, found := x.items[key]
+	if !found {
+		return
+	}
+	heap.Remove(&x.heap, item.index)
+	delete(x.items, key)
+}
+
+func (x *expireIndex) next() (*expiryItem, bool) {
+	if len(x.heap) == 0 {
+		return nil, false
+	}
+	return x.heap[0], true
+}
+
+func (x *expireIndex) len() int {
+	return len(x.heap)
+}
+
+// wakeReaper tells the reaper a TTL earlier than the one it is sleeping
+// towards was set. It never blocks.
+func (s *Store) wakeReaper() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Store) cleanupExpiredKeys() {
+	timer := time.NewTimer(expireIdleWait)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		start := time.Now()
+		wait := s.removeDueKeys(expireBatchSize)
+		if s.latency != nil {
+			s.latency.Record("expire-cycle", time.Since(start))
+		}
+		s.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// removeDueKeys pops up to limit expired keys off the heap and returns how
+// long to wait before the next one is due. It must be called with s.mu held.
+func (s *Store) removeDueKeys(limit int) time.Duration {
+	now := time.Now()
+	for i := 0; i < limit; i++ {
+		item, found := s.expires.next()
+		if !found {
+			return expireIdleWait
+		}
+		if !now.After(item.expiresAt) {
+			// Entry.expired is strict, so aim just past the deadline.
+			return item.expiresAt.Sub(now) + time.Millisecond
+		}
+		s.removeExpired(item.key)
+	}
+	return 0
+}
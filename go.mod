@@ -0,0 +1,3 @@
+module github.com/olivemonk/cask
+
+go 1.27.1
@@ -0,0 +1,169 @@
+package cask
+
+// HashValue maps fields to string values. Commands mutate it in place under
+// the store mutex; a hash left with no fields is deleted.
+type HashValue map[string]string
+
+func (HashValue) Type() string     { return "hash" }
+func (HashValue) Encoding() string { return "hashtable" }
+
+func (h HashValue) Len() int { return len(h) }
+
+// HSet sets each field/value pair in pairs, creating the hash if needed, and
+// returns how many fields were new.
+func (s *Store) HSet(key string, pairs []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, h, err := lookupTyped[HashValue](s, key, false)
+	if err != nil {
+		return 0, err
+	}
+	if h == nil {
+		h = make(HashValue)
+		entry = Entry{value: h}
+	}
+	added := 0
+	for i := 0; i < len(pairs); i += 2 {
+		if _, exists := h[pairs[i]]; !exists {
+			added++
+		}
+		h[pairs[i]] = pairs[i+1]
+	}
+	s.setEntry(key, entry)
+	return added, nil
+}
+
+func (s *Store) HGet(key, field string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, h, err := lookupTyped[HashValue](s, key, true)
+	if err != nil || h == nil {
+		return "", false, err
+	}
+	value, ok := h[field]
+	return value, ok, nil
+}
+
+// HDel removes fields from the hash at key, deleting the key once it is
+// empty, and returns how many fields were removed.
+func (s *Store) HDel(key string, fields []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, h, err := lookupTyped[HashValue](s, key, false)
+	if err != nil || h == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, field := range fields {
+		if _, exists := h[field]; exists {
+			delete(h, field)
+			removed++
+		}
+	}
+	s.deleteIfEmpty(key, h)
+	return removed, nil
+}
+
+// HGetAll returns the hash's fields and values interleaved, in no particular
+// order.
+func (s *Store) HGetAll(key string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, h, err := lookupTyped[HashValue](s, key, true)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]string, 0, 2*len(h))
+	for field, value := range h {
+		items = append(items, field, value)
+	}
+	return items, nil
+}
+
+func (s *Store) HExists(key, field string) (bool, error) {
+	_, ok, err := s.HGet(key, field)
+	return ok, err
+}
+
+func (s *Store) HLen(key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, h, err := lookupTyped[HashValue](s, key, true)
+	return len(h), err
+}
+
+func handleHSet(srv *Server, c *Client, args []string) {
+	if len(args)%2 != 0 {
+		c.writeError("ERR wrong number of arguments for 'hset' command")
+		return
+	}
+	for i := 3; i < len(args); i += 2 {
+		if srv.valueTooLarge(len(args[i])) {
+			c.writeError("ERR value exceeds maximum allowed size")
+			return
+		}
+	}
+	added, err := srv.store.HSet(args[1], args[2:])
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeInt(int64(added))
+}
+
+func handleHGet(srv *Server, c *Client, args []string) {
+	value, ok, err := srv.store.HGet(args[1], args[2])
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	if !ok {
+		c.writeNil()
+		return
+	}
+	c.writeBulk(value)
+}
+
+func handleHDel(srv *Server, c *Client, args []string) {
+	removed, err := srv.store.HDel(args[1], args[2:])
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeInt(int64(removed))
+}
+
+func handleHGetAll(srv *Server, c *Client, args []string) {
+	items, err := srv.store.HGetAll(args[1])
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeMapLen(len(items) / 2)
+	for _, item := range items {
+		c.writeBulk(item)
+	}
+}
+
+func handleHExists(srv *Server, c *Client, args []string) {
+	ok, err := srv.store.HExists(args[1], args[2])
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeBool(ok)
+}
+
+func handleHLen(srv *Server, c *Client, args []string) {
+	n, err := srv.store.HLen(args[1])
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeInt(int64(n))
+}
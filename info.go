@@ -0,0 +1,99 @@
+package cask
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+type infoSection struct {
+	name   string
+	fields func(srv *Server) []string
+}
+
+// infoSections lists INFO's sections in the order they are printed.
+var infoSections = []infoSection{
+	{"server", infoServer},
+	{"clients", infoClients},
+	{"memory", infoMemory},
+	{"stats", infoStats},
+	{"keyspace", infoKeyspace},
+}
+
+func infoServer(srv *Server) []string {
+	return []string{
+		fmt.Sprintf("redis_version:%s", redisVersion),
+		fmt.Sprintf("cask_version:%s", Version),
+		fmt.Sprintf("cask_build_date:%s", BuildDate),
+		fmt.Sprintf("os:%s %s", runtime.GOOS, runtime.GOARCH),
+		fmt.Sprintf("go_version:%s", runtime.Version()),
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		fmt.Sprintf("run_id:%s", srv.runID),
+		fmt.Sprintf("uptime_in_seconds:%d", int(time.Since(srv.started).Seconds())),
+	}
+}
+
+func infoClients(srv *Server) []string {
+	return []string{
+		fmt.Sprintf("connected_clients:%d", srv.clients.Count()),
+	}
+}
+
+func infoMemory(srv *Server) []string {
+	used, peak, fragmentation := srv.memoryUsage()
+	return []string{
+		fmt.Sprintf("used_memory:%d", used),
+		fmt.Sprintf("used_memory_peak:%d", peak),
+		fmt.Sprintf("mem_fragmentation_ratio:%.2f", fragmentation),
+	}
+}
+
+func infoStats(srv *Server) []string {
+	storeStats := srv.store.Stats()
+	var processed int64
+	for _, n := range srv.stats.CommandsProcessed() {
+		processed += n
+	}
+	return []string{
+		fmt.Sprintf("total_connections_received:%d", srv.stats.ConnectionsReceived()),
+		fmt.Sprintf("total_commands_processed:%d", processed),
+		fmt.Sprintf("expired_keys:%d", storeStats.ExpiredKeys),
+		fmt.Sprintf("keyspace_hits:%d", storeStats.KeyspaceHits),
+		fmt.Sprintf("keyspace_misses:%d", storeStats.KeyspaceMisses),
+	}
+}
+
+func infoKeyspace(srv *Server) []string {
+	storeStats := srv.store.Stats()
+	if storeStats.Keys == 0 {
+		return nil
+	}
+	return []string{
+		fmt.Sprintf("db0:keys=%d,expires=%d", storeStats.Keys, storeStats.KeysWithExpiry),
+	}
+}
+
+func handleInfo(srv *Server, c *Client, args []string) {
+	wanted := make(map[string]bool)
+	for _, arg := range args[1:] {
+		wanted[strings.ToLower(arg)] = true
+	}
+	all := len(wanted) == 0 || wanted["all"] || wanted["default"] || wanted["everything"]
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(section.name[:1]) + section.name[1:] + "\r\n")
+		for _, field := range section.fields(srv) {
+			b.WriteString(field + "\r\n")
+		}
+	}
+	c.writeBulk(b.String())
+}
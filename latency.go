@@ -0,0 +1,160 @@
+package cask
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyHistoryLen matches Redis: each event keeps its last 160 samples.
+const latencyHistoryLen = 160
+
+type latencySample struct {
+	time    int64 // unix seconds
+	latency int64 // milliseconds
+}
+
+type latencyEvent struct {
+	history []latencySample
+	max     int64
+}
+
+// LatencyMonitor records operations that took at least threshold, keeping the
+// worst sample per event per second. A zero threshold disables it.
+type LatencyMonitor struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	events    map[string]*latencyEvent
+}
+
+func NewLatencyMonitor(threshold time.Duration) *LatencyMonitor {
+	return &LatencyMonitor{
+		threshold: threshold,
+		events:    make(map[string]*latencyEvent),
+	}
+}
+
+func (m *LatencyMonitor) Record(event string, d time.Duration) {
+	if m.threshold == 0 || d < m.threshold {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ev, found := m.events[event]
+	if !found {
+		ev = &latencyEvent{}
+		m.events[event] = ev
+	}
+	sample := latencySample{time: time.Now().Unix(), latency: d.Milliseconds()}
+	if sample.latency > ev.max {
+		ev.max = sample.latency
+	}
+	if n := len(ev.history); n > 0 && ev.history[n-1].time == sample.time {
+		if sample.latency > ev.history[n-1].latency {
+			ev.history[n-1].latency = sample.latency
+		}
+		return
+	}
+	if len(ev.history) == latencyHistoryLen {
+		ev.history = ev.history[1:]
+	}
+	ev.history = append(ev.history, sample)
+}
+
+func (m *LatencyMonitor) History(event string) []latencySample {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ev, found := m.events[event]
+	if !found {
+		return nil
+	}
+	return append([]latencySample(nil), ev.history...)
+}
+
+type latencyLatest struct {
+	event  string
+	sample latencySample
+	max    int64
+}
+
+func (m *LatencyMonitor) Latest() []latencyLatest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	latest := make([]latencyLatest, 0, len(m.events))
+	for name, ev := range m.events {
+		latest = append(latest, latencyLatest{
+			event:  name,
+			sample: ev.history[len(ev.history)-1],
+			max:    ev.max,
+		})
+	}
+	sort.Slice(latest, func(i, j int) bool { return latest[i].event < latest[j].event })
+	return latest
+}
+
+// Reset drops the given events, or all of them if none are named, and returns
+// how many were dropped.
+func (m *LatencyMonitor) Reset(events ...string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(events) == 0 {
+		n := len(m.events)
+		m.events = make(map[string]*latencyEvent)
+		return n
+	}
+	n := 0
+	for _, event := range events {
+		if _, found := m.events[event]; found {
+			delete(m.events, event)
+			n++
+		}
+	}
+	return n
+}
+
+var latencyHelp = []string{
+	"LATEST",
+	"    Return the latest latency sample and the all-time maximum for every event.",
+	"HISTORY <event>",
+	"    Return up to 160 time-latency pairs for <event>.",
+	"RESET [<event> ...]",
+	"    Reset latency data of one or more events, or all events if none are given.",
+}
+
+func handleLatency(srv *Server, c *Client, args []string) {
+	switch strings.ToUpper(args[1]) {
+	case "HELP":
+		c.writeHelp("LATENCY", latencyHelp)
+	case "LATEST":
+		latest := srv.latency.Latest()
+		c.writeArrayLen(len(latest))
+		for _, l := range latest {
+			c.writeArrayLen(4)
+			c.writeBulk(l.event)
+			c.writeInt(l.sample.time)
+			c.writeInt(l.sample.latency)
+			c.writeInt(l.max)
+		}
+	case "HISTORY":
+		if len(args) != 3 {
+			c.writeError("ERR wrong number of arguments for 'latency|history' command")
+			return
+		}
+		history := srv.latency.History(args[2])
+		c.writeArrayLen(len(history))
+		for _, sample := range history {
+			c.writeArrayLen(2)
+			c.writeInt(sample.time)
+			c.writeInt(sample.latency)
+		}
+	case "RESET":
+		c.writeInt(int64(srv.latency.Reset(args[2:]...)))
+	default:
+		c.writeUnknownSubcommand("LATENCY", args[1])
+	}
+}
@@ -0,0 +1,156 @@
+package cask
+
+import (
+	"strconv"
+	"strings"
+)
+
+type lcsMatch struct {
+	aStart, aEnd int
+	bStart, bEnd int
+}
+
+func (m lcsMatch) len() int { return m.aEnd - m.aStart + 1 }
+
+// lcs computes the longest common subsequence of a and b with the classic
+// dynamic-programming table, then walks it back from the end. The contiguous
+// runs it walks through are returned as matches, last first, as Redis does.
+func lcs(a, b string) (string, []lcsMatch) {
+	width := len(b) + 1
+	table := make([]uint32, (len(a)+1)*width)
+	at := func(i, j int) uint32 { return table[i*width+j] }
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			switch {
+			case a[i-1] == b[j-1]:
+				table[i*width+j] = at(i-1, j-1) + 1
+			case at(i-1, j) > at(i, j-1):
+				table[i*width+j] = at(i-1, j)
+			default:
+				table[i*width+j] = at(i, j-1)
+			}
+		}
+	}
+
+	idx := int(at(len(a), len(b)))
+	result := make([]byte, idx)
+	var matches []lcsMatch
+	// cur.aStart == len(a) means no run is being tracked.
+	cur := lcsMatch{aStart: len(a)}
+	for i, j := len(a), len(b); i > 0 && j > 0; {
+		emit := false
+		if a[i-1] == b[j-1] {
+			result[idx-1] = a[i-1]
+			switch {
+			case cur.aStart == len(a):
+				cur = lcsMatch{aStart: i - 1, aEnd: i - 1, bStart: j - 1, bEnd: j - 1}
+			case cur.aStart == i && cur.bStart == j:
+				cur.aStart--
+				cur.bStart--
+			default:
+				emit = true
+			}
+			// A run that reaches the start of either string is complete.
+			if cur.aStart == 0 || cur.bStart == 0 {
+				emit = true
+			}
+			idx--
+			i--
+			j--
+		} else {
+			if at(i-1, j) > at(i, j-1) {
+				i--
+			} else {
+				j--
+			}
+			if cur.aStart != len(a) {
+				emit = true
+			}
+		}
+		if emit {
+			matches = append(matches, cur)
+			cur.aStart = len(a)
+		}
+	}
+	return string(result), matches
+}
+
+func handleLCS(srv *Server, c *Client, args []string) {
+	var getLen, getIdx, withMatchLen bool
+	minMatchLen := 0
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "LEN":
+			getLen = true
+		case "IDX":
+			getIdx = true
+		case "WITHMATCHLEN":
+			withMatchLen = true
+		case "MINMATCHLEN":
+			if i+1 == len(args) {
+				c.writeError("ERR syntax error")
+				return
+			}
+			i++
+			n, err := strconv.Atoi(args[i])
+			if err != nil {
+				c.writeError(ErrNotInt.Error())
+				return
+			}
+			minMatchLen = max(n, 0)
+		default:
+			c.writeError("ERR syntax error")
+			return
+		}
+	}
+	if getLen && getIdx {
+		c.writeError("ERR If you want both the length and indexes, please just use IDX.")
+		return
+	}
+
+	a, b, err := srv.store.GetPair(args[1], args[2])
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	if int64(len(a)+1)*int64(len(b)+1)*4 > srv.config.ProtoMaxBulkLen {
+		c.writeError("ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len")
+		return
+	}
+
+	result, matches := lcs(a, b)
+	switch {
+	case getLen:
+		c.writeInt(int64(len(result)))
+	case getIdx:
+		var kept []lcsMatch
+		for _, m := range matches {
+			if m.len() >= minMatchLen {
+				kept = append(kept, m)
+			}
+		}
+		c.writeMapLen(2)
+		c.writeBulk("matches")
+		c.writeArrayLen(len(kept))
+		for _, m := range kept {
+			if withMatchLen {
+				c.writeArrayLen(3)
+			} else {
+				c.writeArrayLen(2)
+			}
+			c.writeArrayLen(2)
+			c.writeInt(int64(m.aStart))
+			c.writeInt(int64(m.aEnd))
+			c.writeArrayLen(2)
+			c.writeInt(int64(m.bStart))
+			c.writeInt(int64(m.bEnd))
+			if withMatchLen {
+				c.writeInt(int64(m.len()))
+			}
+		}
+		c.writeBulk("len")
+		c.writeInt(int64(len(result)))
+	default:
+		c.writeBulk(result)
+	}
+}
@@ -0,0 +1,237 @@
+package cask
+
+import "strconv"
+
+// ListValue is a deque of strings in a ring buffer, so pushes and pops at
+// either end are O(1). Commands mutate it in place under the store mutex; a
+// list left with no elements is deleted.
+type ListValue struct {
+	buf  []string
+	head int
+	n    int
+}
+
+func (*ListValue) Type() string     { return "list" }
+func (*ListValue) Encoding() string { return "quicklist" }
+
+func (l *ListValue) Len() int { return l.n }
+
+// At returns the i'th element from the head, for 0 <= i < Len().
+func (l *ListValue) At(i int) string {
+	return l.buf[(l.head+i)%len(l.buf)]
+}
+
+func (l *ListValue) grow() {
+	if l.n < len(l.buf) {
+		return
+	}
+	buf := make([]string, max(8, 2*len(l.buf)))
+	for i := 0; i < l.n; i++ {
+		buf[i] = l.At(i)
+	}
+	l.buf = buf
+	l.head = 0
+}
+
+func (l *ListValue) PushFront(s string) {
+	l.grow()
+	l.head = (l.head - 1 + len(l.buf)) % len(l.buf)
+	l.buf[l.head] = s
+	l.n++
+}
+
+func (l *ListValue) PushBack(s string) {
+	l.grow()
+	l.buf[(l.head+l.n)%len(l.buf)] = s
+	l.n++
+}
+
+func (l *ListValue) PopFront() string {
+	s := l.buf[l.head]
+	l.buf[l.head] = ""
+	l.head = (l.head + 1) % len(l.buf)
+	l.n--
+	return s
+}
+
+func (l *ListValue) PopBack() string {
+	i := (l.head + l.n - 1) % len(l.buf)
+	s := l.buf[i]
+	l.buf[i] = ""
+	l.n--
+	return s
+}
+
+// ListPush adds values to the head of the list at key, or to its tail if tail
+// is set, creating the list if needed. It returns the new length.
+func (s *Store) ListPush(key string, values []string, tail bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, l, err := lookupTyped[*ListValue](s, key, false)
+	if err != nil {
+		return 0, err
+	}
+	if l == nil {
+		l = &ListValue{}
+		entry = Entry{value: l}
+	}
+	for _, value := range values {
+		if tail {
+			l.PushBack(value)
+		} else {
+			l.PushFront(value)
+		}
+	}
+	s.setEntry(key, entry)
+	return l.Len(), nil
+}
+
+// ListPop removes up to count elements from the head of the list at key, or
+// from its tail if tail is set, deleting the key once it is empty. It returns
+// nil if there is no list.
+func (s *Store) ListPop(key string, count int, tail bool) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, l, err := lookupTyped[*ListValue](s, key, false)
+	if err != nil || l == nil {
+		return nil, err
+	}
+	items := make([]string, 0, min(count, l.Len()))
+	for len(items) < count && l.Len() > 0 {
+		if tail {
+			items = append(items, l.PopBack())
+		} else {
+			items = append(items, l.PopFront())
+		}
+	}
+	s.deleteIfEmpty(key, l)
+	return items, nil
+}
+
+// LRange returns the elements from start to stop inclusive. Negative indexes
+// count from the tail, and out-of-range indexes are clamped.
+func (s *Store) LRange(key string, start, stop int64) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, l, err := lookupTyped[*ListValue](s, key, true)
+	if err != nil || l == nil {
+		return nil, err
+	}
+	n := int64(l.Len())
+	if start < 0 {
+		start = max(start+n, 0)
+	}
+	if stop < 0 {
+		stop += n
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		return nil, nil
+	}
+	items := make([]string, 0, stop-start+1)
+	for i := start; i <= stop; i++ {
+		items = append(items, l.At(int(i)))
+	}
+	return items, nil
+}
+
+func (s *Store) LLen(key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, l, err := lookupTyped[*ListValue](s, key, true)
+	if err != nil || l == nil {
+		return 0, err
+	}
+	return l.Len(), nil
+}
+
+func handleLPush(srv *Server, c *Client, args []string) {
+	push(srv, c, args, false)
+}
+
+func handleRPush(srv *Server, c *Client, args []string) {
+	push(srv, c, args, true)
+}
+
+func push(srv *Server, c *Client, args []string, tail bool) {
+	for _, value := range args[2:] {
+		if srv.valueTooLarge(len(value)) {
+			c.writeError("ERR value exceeds maximum allowed size")
+			return
+		}
+	}
+	n, err := srv.store.ListPush(args[1], args[2:], tail)
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeInt(int64(n))
+}
+
+func handleLPop(srv *Server, c *Client, args []string) {
+	pop(srv, c, args, false)
+}
+
+func handleRPop(srv *Server, c *Client, args []string) {
+	pop(srv, c, args, true)
+}
+
+// pop replies with a single element, or with an array when a count is given.
+func pop(srv *Server, c *Client, args []string, tail bool) {
+	if len(args) > 3 {
+		c.writeError("ERR syntax error")
+		return
+	}
+	count := 1
+	if len(args) == 3 {
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 0 {
+			c.writeError("ERR value is out of range, must be positive")
+			return
+		}
+		count = n
+	}
+	items, err := srv.store.ListPop(args[1], count, tail)
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	switch {
+	case len(args) == 3 && items == nil:
+		c.writeNilArray()
+	case len(args) == 3:
+		c.writeBulkArray(items)
+	case len(items) == 0:
+		c.writeNil()
+	default:
+		c.writeBulk(items[0])
+	}
+}
+
+func handleLRange(srv *Server, c *Client, args []string) {
+	start, err1 := strconv.ParseInt(args[2], 10, 64)
+	stop, err2 := strconv.ParseInt(args[3], 10, 64)
+	if err1 != nil || err2 != nil {
+		c.writeError(ErrNotInt.Error())
+		return
+	}
+	items, err := srv.store.LRange(args[1], start, stop)
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeBulkArray(items)
+}
+
+func handleLLen(srv *Server, c *Client, args []string) {
+	n, err := srv.store.LLen(args[1])
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeInt(int64(n))
+}
@@ -0,0 +1,168 @@
+package cask
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Rough per-key costs beyond the key and value bytes: the map slot and Entry,
+// and for keys with a TTL their expiry heap item.
+const (
+	entryOverhead  = 64
+	expiryOverhead = 48
+)
+
+// valueSize approximates the bytes a value occupies in memory.
+func valueSize(v Value) int64 {
+	switch v := v.(type) {
+	case StringValue:
+		return 16 + int64(len(v))
+	case IntValue:
+		return 8
+	case HashValue:
+		size := int64(0)
+		for field, value := range v {
+			size += 32 + int64(len(field)+len(value))
+		}
+		return size
+	case *ListValue:
+		size := int64(0)
+		for i := 0; i < v.Len(); i++ {
+			size += 16 + int64(len(v.At(i)))
+		}
+		return size
+	case SetValue:
+		size := int64(0)
+		for member := range v {
+			size += 24 + int64(len(member))
+		}
+		return size
+	}
+	return 0
+}
+
+func entrySize(key string, entry Entry) int64 {
+	size := entryOverhead + int64(len(key)) + valueSize(entry.value)
+	if entry.hasExpiry {
+		size += expiryOverhead
+	}
+	return size
+}
+
+type MemoryStats struct {
+	Keys          int
+	DatasetBytes  int64
+	OverheadBytes int64
+	TypeBytes     map[string]int64
+}
+
+// MemoryUsage estimates the bytes used by key and its value.
+func (s *Store) MemoryUsage(key string) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, found := s.lookup(key)
+	if !found {
+		return 0, false
+	}
+	return entrySize(key, entry), true
+}
+
+// MemoryStats sums MemoryUsage's estimates over the keyspace, splitting the
+// key and value bytes by type from the per-key overhead. It walks every key
+// and collection element under the store lock, so it is only for MEMORY
+// STATS, not for INFO, which monitoring polls.
+func (s *Store) MemoryStats() MemoryStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := MemoryStats{Keys: len(s.data), TypeBytes: make(map[string]int64)}
+	for key, entry := range s.data {
+		data := int64(len(key)) + valueSize(entry.value)
+		stats.DatasetBytes += data
+		stats.TypeBytes[entry.value.Type()] += data
+		stats.OverheadBytes += entrySize(key, entry) - data
+	}
+	return stats
+}
+
+// memoryUsage reads the Go runtime's view of the heap and updates the peak.
+// The peak only reflects the moments someone asked, through INFO or MEMORY
+// STATS, not every allocation.
+func (srv *Server) memoryUsage() (used, peak uint64, fragmentation float64) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	used = ms.HeapAlloc
+	peak = srv.stats.ObserveMemory(used)
+	// Memory obtained from the OS and not yet returned to it, relative to
+	// what is actually allocated, stands in for Redis's RSS-based ratio.
+	if used > 0 {
+		fragmentation = float64(ms.Sys-ms.HeapReleased) / float64(used)
+	}
+	return used, peak, fragmentation
+}
+
+var memoryHelp = []string{
+	"STATS",
+	"    Return information about the memory usage of the server.",
+	"USAGE <key>",
+	"    Return memory in bytes used by <key> and its value.",
+}
+
+func handleMemory(srv *Server, c *Client, args []string) {
+	switch strings.ToUpper(args[1]) {
+	case "HELP":
+		c.writeHelp("MEMORY", memoryHelp)
+	case "USAGE":
+		if len(args) != 3 {
+			c.writeError("ERR wrong number of arguments for 'memory|usage' command")
+			return
+		}
+		size, ok := srv.store.MemoryUsage(args[2])
+		if !ok {
+			c.writeNil()
+			return
+		}
+		c.writeInt(size)
+	case "STATS":
+		if len(args) != 2 {
+			c.writeError("ERR wrong number of arguments for 'memory|stats' command")
+			return
+		}
+		used, peak, fragmentation := srv.memoryUsage()
+		stats := srv.store.MemoryStats()
+		types := make([]string, 0, len(stats.TypeBytes))
+		for typ := range stats.TypeBytes {
+			types = append(types, typ)
+		}
+		sort.Strings(types)
+
+		c.writeMapLen(7 + len(types))
+		c.writeBulk("peak.allocated")
+		c.writeInt(int64(peak))
+		c.writeBulk("total.allocated")
+		c.writeInt(int64(used))
+		c.writeBulk("overhead.total")
+		c.writeInt(stats.OverheadBytes)
+		c.writeBulk("keys.count")
+		c.writeInt(int64(stats.Keys))
+		c.writeBulk("keys.bytes-per-key")
+		if stats.Keys > 0 {
+			c.writeInt((stats.DatasetBytes + stats.OverheadBytes) / int64(stats.Keys))
+		} else {
+			c.writeInt(0)
+		}
+		c.writeBulk("dataset.bytes")
+		c.writeInt(stats.DatasetBytes)
+		for _, typ := range types {
+			c.writeBulk("dataset." + typ + ".bytes")
+			c.writeInt(stats.TypeBytes[typ])
+		}
+		c.writeBulk("fragmentation")
+		c.writeBulk(fmt.Sprintf("%.2f", fragmentation))
+	default:
+		c.writeUnknownSubcommand("MEMORY", args[1])
+	}
+}
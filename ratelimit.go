@@ -0,0 +1,38 @@
+package cask
+
+import (
+	"time"
+)
+
+// rateLimiter is a token bucket refilled at rate tokens per second, holding at
+// most one second's worth of burst. It is only used by its connection's own
+// goroutine, so it needs no locking.
+type rateLimiter struct {
+	rate      float64
+	tokens    float64
+	last      time.Time
+	throttled bool
+}
+
+func newRateLimiter(rate int) *rateLimiter {
+	return &rateLimiter{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token and returns how long the caller must wait before the
+// token is actually available.
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
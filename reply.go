@@ -0,0 +1,117 @@
+package cask
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Replies are buffered in c.w and sent when the connection loop flushes after
+// each command. They are written in RESP2 unless the client switched to RESP3
+// with HELLO, which changes how nulls, maps and sets are framed.
+
+// write buffers s, noting the first line of each command's reply so the audit
+// log can say how a write turned out.
+func (c *Client) write(s string) {
+	if c.reply == "" {
+		c.reply, _, _ = strings.Cut(s, "\r\n")
+	}
+	c.w.WriteString(s)
+}
+
+func (c *Client) writeSimple(s string) {
+	c.write("+" + s + "\r\n")
+}
+
+// writeError replaces any CR or LF in msg with a space, since errors often
+// quote client input and a line break would end the reply early.
+func (c *Client) writeError(msg string) {
+	c.write("-" + errorLineBreaks.Replace(msg) + "\r\n")
+}
+
+var errorLineBreaks = strings.NewReplacer("\r", " ", "\n", " ")
+
+func (c *Client) writeInt(n int64) {
+	c.write(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+func (c *Client) writeBool(b bool) {
+	if b {
+		c.writeInt(1)
+	} else {
+		c.writeInt(0)
+	}
+}
+
+func (c *Client) writeBulk(s string) {
+	c.write("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (c *Client) writeNil() {
+	if c.resp == 3 {
+		c.write("_\r\n")
+		return
+	}
+	c.write("$-1\r\n")
+}
+
+// writeNilArray writes the null array RESP2 uses for a missing collection.
+func (c *Client) writeNilArray() {
+	if c.resp == 3 {
+		c.write("_\r\n")
+		return
+	}
+	c.write("*-1\r\n")
+}
+
+// writeMapLen starts a map of n key-value pairs, which RESP2 sends as a flat
+// array of 2n elements.
+func (c *Client) writeMapLen(n int) {
+	if c.resp == 3 {
+		c.write("%" + strconv.Itoa(n) + "\r\n")
+		return
+	}
+	c.writeArrayLen(2 * n)
+}
+
+func (c *Client) writeBulkSet(items []string) {
+	if c.resp == 3 {
+		c.write("~" + strconv.Itoa(len(items)) + "\r\n")
+	} else {
+		c.writeArrayLen(len(items))
+	}
+	for _, item := range items {
+		c.writeBulk(item)
+	}
+}
+
+func (c *Client) writeArrayLen(n int) {
+	c.write("*" + strconv.Itoa(n) + "\r\n")
+}
+
+func (c *Client) writeBulkArray(items []string) {
+	c.writeArrayLen(len(items))
+	for _, item := range items {
+		c.writeBulk(item)
+	}
+}
+
+// writeHelp replies to "<command> HELP" with lines describing its
+// subcommands, framed the same way for every command.
+func (c *Client) writeHelp(command string, lines []string) {
+	c.writeArrayLen(len(lines) + 3)
+	c.writeSimple(command + " <subcommand> [<arg> [value] [opt] ...]. Subcommands are:")
+	for _, line := range lines {
+		c.writeSimple(line)
+	}
+	c.writeSimple("HELP")
+	c.writeSimple("    Print this help.")
+}
+
+func (c *Client) writeUnknownSubcommand(command, subcommand string) {
+	c.writeError(fmt.Sprintf("ERR unknown subcommand '%s'. Try %s HELP.", subcommand, command))
+}
+
+func (c *Client) flush() error {
+	return c.w.Flush()
+}
@@ -0,0 +1,624 @@
+package cask
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// Addr is the TCP address for client connections, e.g. ":6380". Use
+	// "127.0.0.1:0" for an ephemeral port and read it back with Server.Addr.
+	Addr string
+
+	// TLSCertFile and TLSKeyFile enable TLS. Without TLSAddr the main
+	// listener is wrapped; with it TLS is served there and Addr stays plain.
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSCAFile   string
+	TLSAddr     string
+
+	UnixSocket  string
+	MetricsAddr string
+
+	// ClientRateLimit caps each connection at this many commands per second
+	// by delaying it; 0 means unlimited.
+	ClientRateLimit int
+
+	// ProtoMaxBulkLen is the largest bulk string a client may send; 0 means
+	// DefaultProtoMaxBulkLen.
+	ProtoMaxBulkLen int64
+
+	// ProtoMaxLineLen is the longest *N or $N header line a client may send;
+	// 0 means DefaultProtoMaxLineLen.
+	ProtoMaxLineLen int
+
+	// MaxValueSize rejects writes whose resulting value is larger than this
+	// many bytes; 0 means unlimited.
+	MaxValueSize int64
+
+	// LatencyMonitorThreshold is the shortest operation LATENCY records; 0
+	// disables latency monitoring.
+	LatencyMonitorThreshold time.Duration
+
+	// ExpireJitter spreads relative TTLs set by SET, GETEX and EXPIRE
+	// randomly over ±ExpireJitter percent of the requested value, so keys
+	// given the same TTL together don't all expire at once. TTL reports the
+	// jittered value. It must be below 100; 0 disables jitter.
+	ExpireJitter int
+
+	// WriteTimeout bounds each write of a reply to a client. A client that
+	// stops reading is disconnected once it expires; 0 means no limit.
+	WriteTimeout time.Duration
+
+	// TCPKeepAlive is the keepalive probe period for TCP clients, including
+	// those over TLS; 0 disables keepalives.
+	TCPKeepAlive time.Duration
+
+	// AuditLog, if set, is a file to which every write command that didn't
+	// fail is appended, along with its reply. AuditRedactValues leaves out
+	// all arguments but the keys, and the value of integer replies.
+	AuditLog          string
+	AuditRedactValues bool
+
+	// RequirePass, if set, is the default user's password, so connections
+	// must AUTH before running other commands.
+	RequirePass string
+
+	// Users are ACL rules applied at startup, each a user name followed by
+	// ACL SETUSER rules, e.g. "alice on >secret ~app:* +@read".
+	Users []string
+
+	// Logger receives listener addresses, connection events and errors; nil
+	// discards them.
+	Logger *log.Logger
+}
+
+const (
+	DefaultProtoMaxBulkLen = 512 << 20
+	DefaultProtoMaxLineLen = 64 << 10
+	maxMultibulkLen        = 1024 * 1024
+
+	// The parser trusts a client's declared sizes only this far up front;
+	// beyond them, memory grows as the arguments actually arrive.
+	preallocArgs = 1024
+	bulkChunkLen = 64 << 10
+)
+
+type Server struct {
+	config  Config
+	store   *Store
+	clients *ClientRegistry
+	stats   *Stats
+	latency *LatencyMonitor
+	acl     *ACL
+	audit   *auditLog
+	nodeID  string
+	runID   string
+	started time.Time
+
+	mu            sync.Mutex
+	closed        bool
+	ln            net.Listener
+	listeners     []net.Listener
+	metricsServer *http.Server
+	wg            sync.WaitGroup
+	// done is closed once Close has finished cleaning up.
+	done chan struct{}
+}
+
+func NewServer(config Config) *Server {
+	if config.ProtoMaxBulkLen <= 0 {
+		config.ProtoMaxBulkLen = DefaultProtoMaxBulkLen
+	}
+	if config.ProtoMaxLineLen <= 0 {
+		config.ProtoMaxLineLen = DefaultProtoMaxLineLen
+	}
+	if config.Logger == nil {
+		config.Logger = log.New(io.Discard, "", 0)
+	}
+	latency := NewLatencyMonitor(config.LatencyMonitorThreshold)
+	store := NewStore()
+	store.SetLatencyMonitor(latency)
+	return &Server{
+		config:  config,
+		store:   store,
+		clients: NewClientRegistry(),
+		stats:   NewStats(),
+		latency: latency,
+		acl:     NewACL(),
+		nodeID:  randomID(),
+		runID:   randomID(),
+		started: time.Now(),
+		done:    make(chan struct{}),
+	}
+}
+
+// Listen binds every configured listener without accepting connections yet,
+// so callers can read Addr before calling Serve.
+func (srv *Server) Listen() error {
+	if srv.config.ExpireJitter < 0 || srv.config.ExpireJitter >= 100 {
+		return fmt.Errorf("expire jitter must be between 0 and 99 percent, got %d", srv.config.ExpireJitter)
+	}
+	if srv.config.RequirePass != "" {
+		srv.acl.SetUser(defaultUserName, []string{"resetpass", ">" + srv.config.RequirePass})
+	}
+	for _, line := range srv.config.Users {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if err := srv.acl.SetUser(fields[0], fields[1:]); err != nil {
+			return fmt.Errorf("loading ACL user %q: %s", fields[0], strings.TrimPrefix(err.Error(), "ERR "))
+		}
+	}
+
+	ln, err := net.Listen("tcp", srv.config.Addr)
+	if err != nil {
+		return fmt.Errorf("starting server: %w", err)
+	}
+	srv.ln = ln
+	srv.listeners = append(srv.listeners, ln)
+
+	if srv.config.TLSCertFile != "" {
+		config, err := loadTLSConfig(srv.config.TLSCertFile, srv.config.TLSKeyFile, srv.config.TLSCAFile)
+		if err != nil {
+			srv.closeListeners()
+			return fmt.Errorf("loading TLS configuration: %w", err)
+		}
+		if srv.config.TLSAddr == "" {
+			srv.ln = tls.NewListener(ln, config)
+			srv.listeners[0] = srv.ln
+		} else {
+			tlsLn, err := tls.Listen("tcp", srv.config.TLSAddr, config)
+			if err != nil {
+				srv.closeListeners()
+				return fmt.Errorf("starting TLS listener: %w", err)
+			}
+			srv.listeners = append(srv.listeners, tlsLn)
+			srv.config.Logger.Printf("TLS listening on %s", tlsLn.Addr())
+		}
+	}
+
+	if srv.config.UnixSocket != "" {
+		unixLn, err := listenUnix(srv.config.UnixSocket)
+		if err != nil {
+			srv.closeListeners()
+			return fmt.Errorf("starting Unix socket listener: %w", err)
+		}
+		srv.listeners = append(srv.listeners, unixLn)
+		srv.config.Logger.Printf("Listening on Unix socket %s", srv.config.UnixSocket)
+	}
+
+	if srv.config.AuditLog != "" {
+		audit, err := openAuditLog(srv.config.AuditLog, srv.config.AuditRedactValues, srv.config.Logger)
+		if err != nil {
+			srv.closeListeners()
+			return fmt.Errorf("opening audit log: %w", err)
+		}
+		srv.audit = audit
+	}
+
+	if srv.config.MetricsAddr != "" {
+		metricsLn, err := net.Listen("tcp", srv.config.MetricsAddr)
+		if err != nil {
+			srv.closeListeners()
+			if srv.audit != nil {
+				srv.audit.Close()
+			}
+			return fmt.Errorf("starting metrics server: %w", err)
+		}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metricsHandler(srv.store, srv.clients, srv.stats))
+		srv.metricsServer = &http.Server{Handler: mux, ErrorLog: srv.config.Logger}
+		srv.wg.Add(1)
+		go func() {
+			defer srv.wg.Done()
+			srv.metricsServer.Serve(metricsLn)
+		}()
+		srv.config.Logger.Printf("Metrics listening on %s", metricsLn.Addr())
+	}
+	return nil
+}
+
+// Serve accepts connections on the listeners bound by Listen and blocks until
+// Close has finished.
+func (srv *Server) Serve() error {
+	for _, l := range srv.listeners[1:] {
+		srv.wg.Add(1)
+		go srv.serve(l)
+	}
+	srv.wg.Add(1)
+	srv.serve(srv.ln)
+	<-srv.done
+	return nil
+}
+
+func (srv *Server) ListenAndServe() error {
+	if err := srv.Listen(); err != nil {
+		return err
+	}
+	return srv.Serve()
+}
+
+func (srv *Server) Addr() net.Addr {
+	return srv.ln.Addr()
+}
+
+// Close stops accepting connections, disconnects every client, waits for their
+// handlers to return and stops the store's background work. Closing a Unix
+// listener also unlinks its socket file.
+func (srv *Server) Close() error {
+	srv.mu.Lock()
+	if srv.closed {
+		srv.mu.Unlock()
+		return nil
+	}
+	srv.closed = true
+	srv.closeListeners()
+	if srv.metricsServer != nil {
+		srv.metricsServer.Close()
+	}
+	srv.mu.Unlock()
+
+	srv.clients.Kill(func(*Client) bool { return true })
+	srv.wg.Wait()
+	if srv.audit != nil {
+		srv.audit.Close()
+	}
+	srv.store.Close()
+	close(srv.done)
+	return nil
+}
+
+func (srv *Server) closeListeners() {
+	for _, l := range srv.listeners {
+		l.Close()
+	}
+}
+
+func (srv *Server) handleConnection(c *Client) {
+	conn := c.conn
+	defer srv.wg.Done()
+	defer conn.Close()
+	defer srv.clients.Unregister(c.id)
+	srv.stats.RecordConnection()
+	srv.config.Logger.Printf("Client connected: %s", c.addr)
+	c.r = bufio.NewReader(conn)
+
+	for {
+		args, err := srv.readCommand(c.r)
+		if err != nil {
+			var perr protocolError
+			if errors.As(err, &perr) {
+				c.writeError(perr.Error())
+				c.flush()
+			} else if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				srv.config.Logger.Println("Error reading from client:", err)
+			}
+			break
+		}
+
+		if c.limiter != nil {
+			srv.throttle(c)
+		}
+		srv.dispatch(c, args)
+		if err := c.flush(); err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				srv.config.Logger.Printf("Client %s stopped reading replies, closing connection", c.addr)
+			}
+			break
+		}
+		if c.quit {
+			break
+		}
+	}
+}
+
+// protocolError is a malformed request. The client gets it as an error reply
+// and is then disconnected, since the rest of the stream can't be trusted to
+// be in step with the parser.
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "ERR Protocol error: " + string(e)
+}
+
+// readCommand reads one multibulk request. It returns a protocolError for
+// malformed input and the reader's error if the connection fails. Like Redis,
+// it skips empty and negative multibulk counts.
+func (srv *Server) readCommand(reader *bufio.Reader) ([]string, error) {
+	var numArgs int
+	for numArgs <= 0 {
+		line, err := readLine(reader, srv.config.ProtoMaxLineLen)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '*' {
+			return nil, protocolError(fmt.Sprintf("expected '*', got '%s'", firstByte(line)))
+		}
+		numArgs, err = strconv.Atoi(line[1:])
+		if err != nil || numArgs > maxMultibulkLen {
+			return nil, protocolError("invalid multibulk length")
+		}
+	}
+
+	args := make([]string, 0, min(numArgs, preallocArgs))
+	for i := 0; i < numArgs; i++ {
+		line, err := readLine(reader, srv.config.ProtoMaxLineLen)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, protocolError(fmt.Sprintf("expected '$', got '%s'", firstByte(line)))
+		}
+		bulkLen, err := strconv.ParseInt(line[1:], 10, 64)
+		// Checking the cap here also keeps bulkLen+2 from overflowing.
+		if err != nil || bulkLen < 0 || bulkLen > srv.config.ProtoMaxBulkLen {
+			return nil, protocolError("invalid bulk length")
+		}
+
+		bulk, err := readBulk(reader, int(bulkLen+2))
+		if err != nil {
+			return nil, err
+		}
+		if bulk[bulkLen] != '\r' || bulk[bulkLen+1] != '\n' {
+			return nil, protocolError("expected CRLF after bulk string")
+		}
+		args = append(args, string(bulk[:bulkLen]))
+	}
+	return args, nil
+}
+
+// readBulk reads n bytes. Past bulkChunkLen it reads a chunk at a time,
+// growing the buffer as data arrives, so a header announcing a huge bulk
+// costs nothing until the client actually sends it.
+func readBulk(reader *bufio.Reader, n int) ([]byte, error) {
+	if n <= bulkChunkLen {
+		bulk := make([]byte, n)
+		_, err := io.ReadFull(reader, bulk)
+		return bulk, err
+	}
+	bulk := make([]byte, 0, bulkChunkLen)
+	for len(bulk) < n {
+		chunk := min(n-len(bulk), bulkChunkLen)
+		bulk = slices.Grow(bulk, chunk)
+		read, err := io.ReadFull(reader, bulk[len(bulk):len(bulk)+chunk])
+		bulk = bulk[:len(bulk)+read]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bulk, nil
+}
+
+// readLine reads a line and strips its line ending. It gives up with a
+// protocolError once the line is longer than max, so a client that never
+// sends a newline can't make it buffer without bound.
+func readLine(reader *bufio.Reader, max int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > max+2 {
+			return "", protocolError("too big header line")
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+// firstByte returns line's first byte for an error message, escaped so a
+// control character can't break the reply.
+func firstByte(line string) string {
+	if line == "" {
+		return ""
+	}
+	quoted := strconv.Quote(line[:1])
+	return quoted[1 : len(quoted)-1]
+}
+
+func (srv *Server) throttle(c *Client) {
+	wait := c.limiter.reserve(time.Now())
+	if wait == 0 {
+		c.limiter.throttled = false
+		return
+	}
+	if !c.limiter.throttled {
+		srv.config.Logger.Printf("Client %s exceeded %d commands/sec, throttling", c.addr, srv.config.ClientRateLimit)
+		c.limiter.throttled = true
+	}
+	time.Sleep(wait)
+}
+
+// jitterTTL applies Config.ExpireJitter to a positive ttl.
+func (srv *Server) jitterTTL(ttl time.Duration) time.Duration {
+	if srv.config.ExpireJitter == 0 || ttl <= 0 {
+		return ttl
+	}
+	spread := float64(ttl) * float64(srv.config.ExpireJitter) / 100
+	delta := time.Duration(spread * (2*srv.store.RandFloat64() - 1))
+	if delta > 0 && ttl > math.MaxInt64-delta {
+		return math.MaxInt64
+	}
+	return ttl + delta
+}
+
+func (srv *Server) valueTooLarge(size int) bool {
+	return srv.config.MaxValueSize > 0 && int64(size) > srv.config.MaxValueSize
+}
+
+func (srv *Server) dispatch(c *Client, args []string) {
+	cmd, ok := lookupCommand(args[0])
+	if !ok {
+		c.writeError(unknownCommandError(args))
+		return
+	}
+	if !cmd.checkArity(len(args)) {
+		c.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", cmd.name))
+		return
+	}
+	if cmd.flags&flagNoAuth == 0 {
+		if !c.Authenticated() {
+			c.writeError("NOAUTH Authentication required.")
+			return
+		}
+		if err := srv.acl.Check(c.User(), cmd, args); err != nil {
+			c.writeError(err.Error())
+			return
+		}
+	}
+	c.reply = ""
+	start := time.Now()
+	cmd.handler(srv, c, args)
+	if cmd.flags&flagBlocking == 0 {
+		srv.latency.Record("command", time.Since(start))
+	}
+	// Writes are audited once they have run, leaving out those rejected
+	// with an error, which changed nothing.
+	if srv.audit != nil && cmd.flags&flagWrite != 0 && !strings.HasPrefix(c.reply, "-") {
+		srv.audit.Record(c, cmd, args, c.reply)
+	}
+	srv.stats.RecordCommand(cmd.name)
+}
+
+func (srv *Server) serve(ln net.Listener) {
+	defer srv.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			srv.config.Logger.Println("Failed to accept connection:", err)
+			continue
+		}
+		srv.setKeepAlive(conn)
+
+		// Registering under srv.mu guarantees Close either sees this client
+		// when it kills everyone or that we see closed here.
+		srv.mu.Lock()
+		if srv.closed {
+			srv.mu.Unlock()
+			conn.Close()
+			return
+		}
+		c := srv.registerClient(conn)
+		srv.wg.Add(1)
+		srv.mu.Unlock()
+		go srv.handleConnection(c)
+	}
+}
+
+// registerClient sets up a newly accepted connection. Like Redis, it is
+// authenticated from the start if the default user needs no password, so
+// setting one later only affects connections made after that.
+func (srv *Server) registerClient(conn net.Conn) *Client {
+	c := srv.clients.Register(conn)
+	if !srv.acl.AuthRequired() {
+		c.Authenticate(defaultUserName)
+	}
+	if srv.config.WriteTimeout > 0 {
+		c.w.Reset(&deadlineWriter{conn: conn, timeout: srv.config.WriteTimeout})
+	}
+	if srv.config.ClientRateLimit > 0 {
+		c.limiter = newRateLimiter(srv.config.ClientRateLimit)
+	}
+	return c
+}
+
+// deadlineWriter gives every write to conn its own deadline, so a reply is
+// bounded however long the command took to produce it.
+type deadlineWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	return w.conn.Write(p)
+}
+
+// setKeepAlive applies Config.TCPKeepAlive to conn. Unix socket connections
+// have no keepalive and are left alone.
+func (srv *Server) setKeepAlive(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if srv.config.TCPKeepAlive <= 0 {
+		tcpConn.SetKeepAlive(false)
+		return
+	}
+	tcpConn.SetKeepAlive(true)
+	tcpConn.SetKeepAlivePeriod(srv.config.TCPKeepAlive)
+}
+
+// randomID returns a random 40-character hex id, the form Redis uses for run
+// and cluster node ids.
+func randomID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func loadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
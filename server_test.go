@@ -0,0 +1,469 @@
+package cask
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, config Config) *Server {
+	t.Helper()
+	srv := NewServer(config)
+	t.Cleanup(func() { srv.Close() })
+	return srv
+}
+
+// startTestServer serves config on an ephemeral port and returns the address.
+func startTestServer(t *testing.T, config Config) (*Server, string) {
+	t.Helper()
+	config.Addr = "127.0.0.1:0"
+	srv := newTestServer(t, config)
+	if err := srv.Listen(); err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go srv.Serve()
+	return srv, srv.Addr().String()
+}
+
+func TestReadCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{name: "command", input: "*2\r\n$3\r\nGET\r\n$1\r\nk\r\n", want: []string{"GET", "k"}},
+		{name: "empty bulk", input: "*2\r\n$4\r\nECHO\r\n$0\r\n\r\n", want: []string{"ECHO", ""}},
+		{name: "binary bulk", input: "*1\r\n$4\r\na\r\nb\r\n", want: []string{"a\r\nb"}},
+		{name: "empty multibulk is skipped", input: "*0\r\n*1\r\n$4\r\nPING\r\n", want: []string{"PING"}},
+		{name: "negative multibulk is skipped", input: "*-1\r\n*1\r\n$4\r\nPING\r\n", want: []string{"PING"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, Config{})
+			got, err := srv.readCommand(bufio.NewReader(strings.NewReader(tt.input)))
+			if err != nil {
+				t.Fatalf("readCommand: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readCommand = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCommandMalformed(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{name: "inline command", input: "PING\r\n", wantErr: "expected '*', got 'P'"},
+		{name: "bad multibulk count", input: "*abc\r\n", wantErr: "invalid multibulk length"},
+		{name: "multibulk count over the limit", input: "*1048577\r\n", wantErr: "invalid multibulk length"},
+		{name: "missing $", input: "*1\r\nGET\r\n", wantErr: "expected '$', got 'G'"},
+		{name: "control character instead of $", input: "*1\r\n\x00\r\n", wantErr: `expected '$', got '\x00'`},
+		{name: "bad bulk length", input: "*1\r\n$x\r\n", wantErr: "invalid bulk length"},
+		{name: "negative bulk length", input: "*1\r\n$-1\r\n", wantErr: "invalid bulk length"},
+		{name: "bulk length too short", input: "*1\r\n$2\r\nGET\r\n", wantErr: "expected CRLF after bulk string"},
+		{name: "bulk length too long", input: "*1\r\n$4\r\nGET\r\nxx", wantErr: "expected CRLF after bulk string"},
+		{name: "missing trailing CRLF", input: "*1\r\n$3\r\nGETxx", wantErr: "expected CRLF after bulk string"},
+		{name: "header line too long", input: "*1\r\n$" + strings.Repeat("1", 70000) + "\r\n", wantErr: "too big header line"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, Config{})
+			_, err := srv.readCommand(bufio.NewReader(strings.NewReader(tt.input)))
+			var perr protocolError
+			if !errors.As(err, &perr) {
+				t.Fatalf("readCommand error = %v, want a protocol error", err)
+			}
+			if want := "ERR Protocol error: " + tt.wantErr; err.Error() != want {
+				t.Errorf("readCommand error = %q, want %q", err, want)
+			}
+		})
+	}
+}
+
+func TestReadCommandTruncated(t *testing.T) {
+	for _, input := range []string{"", "*2\r\n$3\r\nGET\r\n", "*1\r\n$3\r\nGE"} {
+		srv := newTestServer(t, Config{})
+		_, err := srv.readCommand(bufio.NewReader(strings.NewReader(input)))
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			t.Errorf("readCommand(%q) error = %v, want EOF", input, err)
+		}
+	}
+}
+
+func TestReadCommandOversizedHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		input   string
+		wantErr string
+	}{
+		{name: "bulk over the default limit", input: "*1\r\n$2000000000\r\n", wantErr: "invalid bulk length"},
+		{name: "bulk length of MaxInt64", input: "*1\r\n$9223372036854775807\r\n", wantErr: "invalid bulk length"},
+		{name: "bulk length overflowing int64", input: "*1\r\n$99999999999999999999\r\n", wantErr: "invalid bulk length"},
+		{name: "bulk over a configured limit", config: Config{ProtoMaxBulkLen: 10}, input: "*1\r\n$11\r\n", wantErr: "invalid bulk length"},
+		{name: "multibulk count of MaxInt64", input: "*9223372036854775807\r\n", wantErr: "invalid multibulk length"},
+		{name: "header over a configured line limit", config: Config{ProtoMaxLineLen: 8}, input: "*1\r\n$1234567890\r\n", wantErr: "too big header line"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t, tt.config)
+			_, err := srv.readCommand(bufio.NewReader(strings.NewReader(tt.input)))
+			if want := "ERR Protocol error: " + tt.wantErr; err == nil || err.Error() != want {
+				t.Errorf("readCommand error = %v, want %q", err, want)
+			}
+		})
+	}
+
+	srv := newTestServer(t, Config{ProtoMaxBulkLen: 10})
+	args, err := srv.readCommand(bufio.NewReader(strings.NewReader("*1\r\n$10\r\n0123456789\r\n")))
+	if err != nil || len(args) != 1 || args[0] != "0123456789" {
+		t.Errorf("bulk at the configured limit = %q, %v", args, err)
+	}
+}
+
+// TestReadCommandDeclaredSizes checks that headers alone, announcing the
+// largest frame the limits allow, don't make the parser allocate for it.
+func TestReadCommandDeclaredSizes(t *testing.T) {
+	srv := newTestServer(t, Config{})
+	input := "*1048576\r\n$536870912\r\n" + strings.Repeat("x", 1000)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := srv.readCommand(bufio.NewReader(strings.NewReader(input)))
+	runtime.ReadMemStats(&after)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("readCommand error = %v, want unexpected EOF", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("headers alone allocated %d bytes", allocated)
+	}
+}
+
+func TestReadCommandLargeBulk(t *testing.T) {
+	srv := newTestServer(t, Config{})
+	value := strings.Repeat("abcdefgh", 100000)
+	input := "*2\r\n$4\r\nECHO\r\n$800000\r\n" + value + "\r\n"
+	args, err := srv.readCommand(bufio.NewReader(strings.NewReader(input)))
+	if err != nil {
+		t.Fatalf("readCommand: %v", err)
+	}
+	if len(args) != 2 || args[1] != value {
+		t.Errorf("large bulk read back wrong: %d args", len(args))
+	}
+}
+
+// TestProtocolErrorCloses checks that a malformed frame gets its error reply
+// and then a clean close.
+func TestProtocolErrorCloses(t *testing.T) {
+	_, addr := startTestServer(t, Config{})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	io.WriteString(conn, "*1\r\n$x\r\n*1\r\n$4\r\nPING\r\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if want := "-ERR Protocol error: invalid bulk length\r\n"; string(reply) != want {
+		t.Errorf("reply = %q, want %q then EOF", reply, want)
+	}
+}
+
+func TestDeadlineWriter(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	// Nothing reads from peer, so the write can only end at its deadline.
+	w := &deadlineWriter{conn: conn, timeout: 50 * time.Millisecond}
+	start := time.Now()
+	_, err := w.Write([]byte("+OK\r\n"))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Write error = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Write took %v", elapsed)
+	}
+
+	// Each write gets a fresh deadline rather than sharing the first one.
+	go io.ReadAll(peer)
+	time.Sleep(100 * time.Millisecond)
+	if _, err := w.Write([]byte("+OK\r\n")); err != nil {
+		t.Errorf("Write after the earlier deadline passed = %v", err)
+	}
+}
+
+// TestClientThatStopsReading checks that a client which never reads its
+// replies is disconnected and doesn't hold up other clients.
+func TestClientThatStopsReading(t *testing.T) {
+	srv, addr := startTestServer(t, Config{WriteTimeout: 200 * time.Millisecond})
+
+	stuck, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer stuck.Close()
+	stuck.(*net.TCPConn).SetReadBuffer(4096)
+
+	// Pipeline far more reply bytes than the socket buffers can hold.
+	value := strings.Repeat("x", 1<<20)
+	io.WriteString(stuck, "*3\r\n$3\r\nSET\r\n$3\r\nbig\r\n$1048576\r\n"+value+"\r\n")
+	io.WriteString(stuck, strings.Repeat("*2\r\n$3\r\nGET\r\n$3\r\nbig\r\n", 128))
+
+	other, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer other.Close()
+	other.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(other)
+	for i := 0; i < 3; i++ {
+		io.WriteString(other, "*1\r\n$4\r\nPING\r\n")
+		if line, err := reader.ReadString('\n'); err != nil || line != "+PONG\r\n" {
+			t.Fatalf("PING from another client = %q, %v", line, err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for srv.clients.Count() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("client that stopped reading still connected; %d clients", srv.clients.Count())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (s *Store) waiterCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, waiting := range s.waiters {
+		n += len(waiting)
+	}
+	return n
+}
+
+// waitFor polls cond until it holds or five seconds pass.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestWaitKeyDisconnect checks that a client which disconnects while blocked
+// in WAITKEY is unregistered and leaves no waiter behind.
+func TestWaitKeyDisconnect(t *testing.T) {
+	srv, addr := startTestServer(t, Config{})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	io.WriteString(conn, "*3\r\n$7\r\nWAITKEY\r\n$1\r\nk\r\n$1\r\n0\r\n")
+	waitFor(t, "WAITKEY to block", func() bool { return srv.store.waiterCount() == 1 })
+
+	conn.Close()
+	waitFor(t, "the client to be unregistered", func() bool { return srv.clients.Count() == 0 })
+	if n := srv.store.waiterCount(); n != 0 {
+		t.Errorf("%d waiters left after the client disconnected", n)
+	}
+}
+
+// TestWaitKeyPipelined checks that a command sent while WAITKEY blocks is
+// kept and answered once WAITKEY returns.
+func TestWaitKeyPipelined(t *testing.T) {
+	srv, addr := startTestServer(t, Config{})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "*3\r\n$7\r\nWAITKEY\r\n$1\r\nk\r\n$1\r\n0\r\n")
+	waitFor(t, "WAITKEY to block", func() bool { return srv.store.waiterCount() == 1 })
+	io.WriteString(conn, "*1\r\n$4\r\nPING\r\n")
+	time.Sleep(50 * time.Millisecond)
+
+	mustSet(t, srv.store, "k", "v", SetOptions{})
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	want := ":1\r\n+PONG\r\n"
+	reply := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != want {
+		t.Errorf("replies = %q, %v, want %q", reply, err, want)
+	}
+}
+
+func encodeCommand(args ...string) string {
+	var b strings.Builder
+	b.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		b.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	return b.String()
+}
+
+// TestBinarySafeValues checks that values holding NUL, CRLF and bare CR bytes
+// round-trip through SET and GET byte for byte.
+func TestBinarySafeValues(t *testing.T) {
+	_, addr := startTestServer(t, Config{})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	for _, value := range []string{"a\x00b", "\x00", "line1\r\nline2", "\r\n", "a\rb", "\r", "\n\r\x00\xff"} {
+		io.WriteString(conn, encodeCommand("SET", "k", value)+encodeCommand("GET", "k"))
+		want := "+OK\r\n$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+		reply := make([]byte, len(want))
+		if _, err := io.ReadFull(reader, reply); err != nil {
+			t.Fatalf("read reply for %q: %v", value, err)
+		}
+		if string(reply) != want {
+			t.Errorf("SET then GET of %q = %q, want %q", value, reply, want)
+		}
+	}
+}
+
+// TestErrorQuotingLineBreaks checks that an error quoting client input with
+// CR or LF stays a single line, so the next reply is still read correctly.
+func TestErrorQuotingLineBreaks(t *testing.T) {
+	_, addr := startTestServer(t, Config{})
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+
+	io.WriteString(conn, encodeCommand("NO\r\nSUCH", "a\rb", "+OK")+encodeCommand("PING"))
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("read error reply: %v", err)
+	}
+	if want := "-ERR unknown command 'NO  SUCH', with args beginning with: 'a b' '+OK' \r\n"; line != want {
+		t.Errorf("error reply = %q, want %q", line, want)
+	}
+	if line, err := reader.ReadString('\n'); err != nil || line != "+PONG\r\n" {
+		t.Errorf("reply after the error = %q, %v, want +PONG", line, err)
+	}
+}
+
+// TestAuditLogOutcomes checks that only writes that ran without an error are
+// audited, each with its reply.
+func TestAuditLogOutcomes(t *testing.T) {
+	path := t.TempDir() + "/audit.log"
+	srv, _ := startTestServer(t, Config{AuditLog: path})
+	tc := newTestClient(t, srv)
+	for _, cmd := range []string{
+		"SET|k|v", "SET|k|v2|NX", "SET|n|9223372036854775807", "INCR|n",
+		"HSET|k|f|v", "SET|k|v|EX|0", "GET|k", "INCR|c", "DEL|k",
+	} {
+		tc.do(strings.Split(cmd, "|")...)
+	}
+	srv.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading audit log: %v", err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		_, entry, _ := strings.Cut(line, " user=default ")
+		got = append(got, entry)
+	}
+	want := []string{
+		`cmd=set keys="k" args="k","v" reply="+OK"`,
+		`cmd=set keys="k" args="k","v2","NX" reply="$-1"`,
+		`cmd=set keys="n" args="n","9223372036854775807" reply="+OK"`,
+		`cmd=incr keys="c" args="c" reply=":1"`,
+		`cmd=del keys="k" args="k" reply=":1"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("audit log entries:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestAuditLogRedaction(t *testing.T) {
+	path := t.TempDir() + "/audit.log"
+	srv, _ := startTestServer(t, Config{AuditLog: path, AuditRedactValues: true})
+	tc := newTestClient(t, srv)
+	tc.do("SET", "k", "secret")
+	tc.do("INCRBY", "c", "42")
+	srv.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading audit log: %v", err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("redacted audit log holds values:\n%s", data)
+	}
+	if !strings.Contains(string(data), `cmd=incrby keys="c" reply=":"`) {
+		t.Errorf("redacted audit log:\n%s\nwant the INCRBY entry without its result", data)
+	}
+}
+
+// TestUnixSocketClientAddrs checks that Unix socket clients get distinct
+// addresses, so CLIENT KILL ADDR disconnects just the one named.
+func TestUnixSocketClientAddrs(t *testing.T) {
+	dir, err := os.MkdirTemp("", "cask")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/cask.sock"
+	srv, _ := startTestServer(t, Config{UnixSocket: path})
+
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("unix", path)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	waitFor(t, "both clients to connect", func() bool { return srv.clients.Count() == 2 })
+
+	clients := srv.clients.List()
+	for i, c := range clients {
+		if want := path + ":" + strconv.FormatInt(c.id, 10); c.addr != want {
+			t.Errorf("client %d addr = %q, want %q", i, c.addr, want)
+		}
+	}
+
+	tc := newTestClient(t, srv)
+	run(t, tc, [][2]string{{"CLIENT|KILL|ADDR|" + clients[0].addr, ":1\r\n"}})
+	if srv.clients.Count() != 2 {
+		t.Errorf("%d clients left, want the other Unix client and the test client", srv.clients.Count())
+	}
+	conns[1].SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conns[1], "*1\r\n$4\r\nPING\r\n")
+	if line, err := bufio.NewReader(conns[1]).ReadString('\n'); err != nil || line != "+PONG\r\n" {
+		t.Errorf("PING from the other Unix client = %q, %v", line, err)
+	}
+}
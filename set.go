@@ -0,0 +1,224 @@
+package cask
+
+// SetValue is an unordered set of strings. Commands mutate it in place under
+// the store mutex; a set left with no members is deleted.
+type SetValue map[string]struct{}
+
+func (SetValue) Type() string     { return "set" }
+func (SetValue) Encoding() string { return "hashtable" }
+
+func (m SetValue) Len() int { return len(m) }
+
+func (m SetValue) members() []string {
+	members := make([]string, 0, len(m))
+	for member := range m {
+		members = append(members, member)
+	}
+	return members
+}
+
+// SAdd adds members to the set at key, creating it if needed, and returns how
+// many were not already present.
+func (s *Store) SAdd(key string, members []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, m, err := lookupTyped[SetValue](s, key, false)
+	if err != nil {
+		return 0, err
+	}
+	if m == nil {
+		m = make(SetValue)
+		entry = Entry{value: m}
+	}
+	added := 0
+	for _, member := range members {
+		if _, exists := m[member]; !exists {
+			m[member] = struct{}{}
+			added++
+		}
+	}
+	s.setEntry(key, entry)
+	return added, nil
+}
+
+// SRem removes members from the set at key, deleting the key once it is
+// empty, and returns how many were removed.
+func (s *Store) SRem(key string, members []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, m, err := lookupTyped[SetValue](s, key, false)
+	if err != nil || m == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		if _, exists := m[member]; exists {
+			delete(m, member)
+			removed++
+		}
+	}
+	s.deleteIfEmpty(key, m)
+	return removed, nil
+}
+
+func (s *Store) SMembers(key string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, m, err := lookupTyped[SetValue](s, key, true)
+	if err != nil {
+		return nil, err
+	}
+	return m.members(), nil
+}
+
+func (s *Store) SIsMember(key, member string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, m, err := lookupTyped[SetValue](s, key, true)
+	if err != nil {
+		return false, err
+	}
+	_, ok := m[member]
+	return ok, nil
+}
+
+func (s *Store) SCard(key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, m, err := lookupTyped[SetValue](s, key, true)
+	return len(m), err
+}
+
+type setOp int
+
+const (
+	setInter setOp = iota
+	setUnion
+	setDiff
+)
+
+// SetAlgebra combines the sets at keys, in order, by op. Missing keys count
+// as empty sets; any key holding another type makes the whole call fail.
+func (s *Store) SetAlgebra(op setOp, keys []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sets := make([]SetValue, len(keys))
+	for i, key := range keys {
+		_, m, err := lookupTyped[SetValue](s, key, true)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = m
+	}
+
+	result := make(SetValue)
+	switch op {
+	case setInter:
+		for member := range sets[0] {
+			inAll := true
+			for _, m := range sets[1:] {
+				if _, ok := m[member]; !ok {
+					inAll = false
+					break
+				}
+			}
+			if inAll {
+				result[member] = struct{}{}
+			}
+		}
+	case setUnion:
+		for _, m := range sets {
+			for member := range m {
+				result[member] = struct{}{}
+			}
+		}
+	case setDiff:
+		for member := range sets[0] {
+			result[member] = struct{}{}
+		}
+		for _, m := range sets[1:] {
+			for member := range m {
+				delete(result, member)
+			}
+		}
+	}
+	return result.members(), nil
+}
+
+func handleSAdd(srv *Server, c *Client, args []string) {
+	for _, member := range args[2:] {
+		if srv.valueTooLarge(len(member)) {
+			c.writeError("ERR value exceeds maximum allowed size")
+			return
+		}
+	}
+	added, err := srv.store.SAdd(args[1], args[2:])
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeInt(int64(added))
+}
+
+func handleSRem(srv *Server, c *Client, args []string) {
+	removed, err := srv.store.SRem(args[1], args[2:])
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeInt(int64(removed))
+}
+
+func handleSMembers(srv *Server, c *Client, args []string) {
+	members, err := srv.store.SMembers(args[1])
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeBulkSet(members)
+}
+
+func handleSIsMember(srv *Server, c *Client, args []string) {
+	ok, err := srv.store.SIsMember(args[1], args[2])
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeBool(ok)
+}
+
+func handleSCard(srv *Server, c *Client, args []string) {
+	n, err := srv.store.SCard(args[1])
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeInt(int64(n))
+}
+
+func handleSInter(srv *Server, c *Client, args []string) {
+	setAlgebra(srv, c, setInter, args[1:])
+}
+
+func handleSUnion(srv *Server, c *Client, args []string) {
+	setAlgebra(srv, c, setUnion, args[1:])
+}
+
+func handleSDiff(srv *Server, c *Client, args []string) {
+	setAlgebra(srv, c, setDiff, args[1:])
+}
+
+func setAlgebra(srv *Server, c *Client, op setOp, keys []string) {
+	members, err := srv.store.SetAlgebra(op, keys)
+	if err != nil {
+		c.writeError(err.Error())
+		return
+	}
+	c.writeBulkSet(members)
+}
@@ -0,0 +1,108 @@
+package cask
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Stats struct {
+	mu                  sync.Mutex
+	connectionsReceived int64
+	commandsProcessed   map[string]int64
+	peakMemory          uint64
+}
+
+func NewStats() *Stats {
+	return &Stats{
+		commandsProcessed: make(map[string]int64),
+	}
+}
+
+func (st *Stats) RecordConnection() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.connectionsReceived++
+}
+
+func (st *Stats) RecordCommand(command string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.commandsProcessed[command]++
+}
+
+func (st *Stats) ConnectionsReceived() int64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return st.connectionsReceived
+}
+
+func (st *Stats) CommandsProcessed() map[string]int64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	counts := make(map[string]int64, len(st.commandsProcessed))
+	for command, n := range st.commandsProcessed {
+		counts[command] = n
+	}
+	return counts
+}
+
+// ObserveMemory records a heap size reading and returns the largest seen.
+func (st *Stats) ObserveMemory(used uint64) uint64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if used > st.peakMemory {
+		st.peakMemory = used
+	}
+	return st.peakMemory
+}
+
+func metricsHandler(store *Store, clients *ClientRegistry, stats *Stats) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+		fmt.Fprintln(w, "# HELP cask_connected_clients Number of connected clients.")
+		fmt.Fprintln(w, "# TYPE cask_connected_clients gauge")
+		fmt.Fprintf(w, "cask_connected_clients %d\n", clients.Count())
+
+		fmt.Fprintln(w, "# HELP cask_connections_received_total Total number of accepted connections.")
+		fmt.Fprintln(w, "# TYPE cask_connections_received_total counter")
+		fmt.Fprintf(w, "cask_connections_received_total %d\n", stats.ConnectionsReceived())
+
+		counts := stats.CommandsProcessed()
+		commands := make([]string, 0, len(counts))
+		for command := range counts {
+			commands = append(commands, command)
+		}
+		sort.Strings(commands)
+		fmt.Fprintln(w, "# HELP cask_commands_processed_total Total number of commands processed, by command.")
+		fmt.Fprintln(w, "# TYPE cask_commands_processed_total counter")
+		for _, command := range commands {
+			fmt.Fprintf(w, "cask_commands_processed_total{command=%q} %d\n", strings.ToLower(command), counts[command])
+		}
+
+		storeStats := store.Stats()
+		fmt.Fprintln(w, "# HELP cask_keys Number of keys in the keyspace.")
+		fmt.Fprintln(w, "# TYPE cask_keys gauge")
+		fmt.Fprintf(w, "cask_keys %d\n", storeStats.Keys)
+
+		fmt.Fprintln(w, "# HELP cask_expired_keys_total Total number of keys removed because their TTL elapsed.")
+		fmt.Fprintln(w, "# TYPE cask_expired_keys_total counter")
+		fmt.Fprintf(w, "cask_expired_keys_total %d\n", storeStats.ExpiredKeys)
+
+		fmt.Fprintln(w, "# HELP cask_keyspace_hits_total Total number of key lookups that found a live key.")
+		fmt.Fprintln(w, "# TYPE cask_keyspace_hits_total counter")
+		fmt.Fprintf(w, "cask_keyspace_hits_total %d\n", storeStats.KeyspaceHits)
+
+		fmt.Fprintln(w, "# HELP cask_keyspace_misses_total Total number of key lookups that found a missing or expired key.")
+		fmt.Fprintln(w, "# TYPE cask_keyspace_misses_total counter")
+		fmt.Fprintf(w, "cask_keyspace_misses_total %d\n", storeStats.KeyspaceMisses)
+	}
+}
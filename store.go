@@ -0,0 +1,801 @@
+package cask
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Value is the typed payload of an Entry. Every data type implements it so
+// commands can check a key's type in one place instead of each rolling its own.
+type Value interface {
+	Type() string
+	// Encoding names the value's internal representation.
+	Encoding() string
+}
+
+// collection is implemented by values that hold several elements. A key whose
+// collection loses its last element is deleted; see deleteIfEmpty.
+type collection interface {
+	Len() int
+}
+
+type StringValue string
+
+func (StringValue) Type() string     { return "string" }
+func (StringValue) Encoding() string { return "raw" }
+
+// IntValue is a string that holds a canonical base-10 int64, stored as the
+// integer itself.
+type IntValue int64
+
+func (IntValue) Type() string     { return "string" }
+func (IntValue) Encoding() string { return "int" }
+
+func (v IntValue) String() string { return strconv.FormatInt(int64(v), 10) }
+
+// newStringValue picks the string encoding for s. Only strings that format
+// back to exactly themselves are stored as integers, so "007" or "+1" keep
+// their bytes.
+func newStringValue(s string) Value {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(n, 10) == s {
+		return IntValue(n)
+	}
+	return StringValue(s)
+}
+
+var (
+	ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	ErrNotInt    = errors.New("ERR value is not an integer or out of range")
+	ErrOverflow  = errors.New("ERR increment or decrement would overflow")
+	ErrNotFloat  = errors.New("ERR value is not a valid float")
+	ErrNaNOrInf  = errors.New("ERR increment would produce NaN or Infinity")
+)
+
+// parseFloat parses a finite float the way INCRBYFLOAT accepts it.
+func parseFloat(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+type Entry struct {
+	value     Value
+	expiresAt time.Time
+	hasExpiry bool
+}
+
+func (e Entry) expired(now time.Time) bool {
+	return e.hasExpiry && now.After(e.expiresAt)
+}
+
+type Store struct {
+	mu          sync.Mutex
+	data        map[string]Entry
+	expires     *expireIndex
+	expiredKeys int64
+	hits        int64
+	misses      int64
+	latency     *LatencyMonitor
+	sortedKeys  bool
+	// typeCounts counts keys by value type, so RANDOMKEY TYPE knows at once
+	// when no key has the type.
+	typeCounts map[string]int
+	// rng is the source of every random choice the server makes, so a test
+	// can reproduce them with Seed.
+	rng    *rand.Rand
+	seeded bool
+	// waiters holds the channels of WAITKEY calls blocked on each key.
+	waiters map[string][]chan struct{}
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+func NewStore() *Store {
+	store := &Store{
+		data:       make(map[string]Entry),
+		expires:    newExpireIndex(),
+		typeCounts: make(map[string]int),
+		waiters:    make(map[string][]chan struct{}),
+		rng:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	go store.cleanupExpiredKeys()
+	return store
+}
+
+// Close stops the background expiry goroutine.
+func (s *Store) Close() {
+	close(s.done)
+}
+
+func (s *Store) SetLatencyMonitor(m *LatencyMonitor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.latency = m
+}
+
+// SetSortedKeys makes KEYS return its matches in lexicographic order, for
+// tests that compare its output. It costs a sort, and with a LIMIT a scan of
+// the whole keyspace so that the first matches in order are the ones returned.
+func (s *Store) SetSortedKeys(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sortedKeys = on
+}
+
+// Seed reseeds the store's random source, making RANDOMKEY and TTL jitter
+// deterministic from then on. Because map order is itself random, RANDOMKEY
+// then sorts the candidate keys first, which makes it O(n log n).
+func (s *Store) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rng.Seed(seed)
+	s.seeded = true
+}
+
+// RandFloat64 returns a number in [0, 1) from the store's random source.
+func (s *Store) RandFloat64() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.rng.Float64()
+}
+
+// lookup returns the live entry for key, removing it first if it has expired.
+// It must be called with s.mu held.
+func (s *Store) lookup(key string) (Entry, bool) {
+	entry, found := s.data[key]
+	if !found {
+		return Entry{}, false
+	}
+	if entry.expired(time.Now()) {
+		s.removeExpired(key)
+		return Entry{}, false
+	}
+	return entry, true
+}
+
+// lookupRead is lookup for commands that read a key, counting the lookup as a
+// keyspace hit or miss. It must be called with s.mu held.
+func (s *Store) lookupRead(key string) (Entry, bool) {
+	entry, found := s.lookup(key)
+	if found {
+		s.hits++
+	} else {
+		s.misses++
+	}
+	return entry, found
+}
+
+// setEntry and deleteEntry are the only writers of s.data, which keeps the
+// expiry index in step with it and lets setEntry wake WAITKEY callers. Both
+// must be called with s.mu held.
+func (s *Store) setEntry(key string, entry Entry) {
+	if old, found := s.data[key]; found {
+		s.typeCounts[old.value.Type()]--
+	}
+	s.data[key] = entry
+	s.typeCounts[entry.value.Type()]++
+	if waiting, ok := s.waiters[key]; ok {
+		for _, ch := range waiting {
+			close(ch)
+		}
+		delete(s.waiters, key)
+	}
+	if entry.hasExpiry {
+		if s.expires.set(key, entry.expiresAt) {
+			s.wakeReaper()
+		}
+	} else {
+		s.expires.remove(key)
+	}
+}
+
+func (s *Store) deleteEntry(key string) bool {
+	entry, found := s.data[key]
+	if !found {
+		return false
+	}
+	s.typeCounts[entry.value.Type()]--
+	delete(s.data, key)
+	s.expires.remove(key)
+	return true
+}
+
+// stringOf is the single type check for string commands: it returns v's bytes
+// if v is a string of any encoding and ErrWrongType otherwise.
+func stringOf(v Value) (string, error) {
+	switch v := v.(type) {
+	case StringValue:
+		return string(v), nil
+	case IntValue:
+		return v.String(), nil
+	}
+	return "", ErrWrongType
+}
+
+// intOf is the integer counterpart of stringOf. Only canonical integers count,
+// which are exactly the strings stored as IntValue, so "007" and "+5" are
+// rejected with ErrNotInt as in Redis.
+func intOf(v Value) (int64, error) {
+	if _, err := stringOf(v); err != nil {
+		return 0, err
+	}
+	n, ok := v.(IntValue)
+	if !ok {
+		return 0, ErrNotInt
+	}
+	return int64(n), nil
+}
+
+// lookupString is lookup for commands that only operate on strings. It must be
+// called with s.mu held.
+func (s *Store) lookupString(key string) (string, bool, error) {
+	entry, found := s.lookupRead(key)
+	if !found {
+		return "", false, nil
+	}
+	str, err := stringOf(entry.value)
+	if err != nil {
+		return "", false, err
+	}
+	return str, true, nil
+}
+
+// lookupTyped is lookup for commands on one collection type: it returns the
+// value at key as a T, the zero T if there is none, or ErrWrongType. With read
+// set it counts a keyspace hit or miss. It must be called with s.mu held.
+func lookupTyped[T Value](s *Store, key string, read bool) (Entry, T, error) {
+	var entry Entry
+	var found bool
+	if read {
+		entry, found = s.lookupRead(key)
+	} else {
+		entry, found = s.lookup(key)
+	}
+	var v T
+	if !found {
+		return Entry{}, v, nil
+	}
+	v, ok := entry.value.(T)
+	if !ok {
+		return Entry{}, v, ErrWrongType
+	}
+	return entry, v, nil
+}
+
+// deleteIfEmpty deletes key once a command has removed the last element of
+// coll, the collection stored there, since an empty collection is never left
+// in the keyspace. It must be called with s.mu held.
+func (s *Store) deleteIfEmpty(key string, coll collection) {
+	if coll.Len() == 0 {
+		s.deleteEntry(key)
+	}
+}
+
+type SetOptions struct {
+	// ExpiresAt is the new TTL deadline. Zero stores the value without a
+	// TTL; a time in the past stores it already expired.
+	ExpiresAt time.Time
+	// IfMissing and IfExists are SET's NX and XX conditions.
+	IfMissing bool
+	IfExists  bool
+	// Get asks for the previous value, which must then be a string.
+	Get bool
+}
+
+type SetResult struct {
+	Written  bool
+	Old      string
+	OldFound bool
+}
+
+// Set stores a string value if opts' condition holds.
+func (s *Store) Set(key, value string, opts SetOptions) (SetResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result SetResult
+	entry, found := s.lookup(key)
+	if found && opts.Get {
+		old, err := stringOf(entry.value)
+		if err != nil {
+			return result, err
+		}
+		result.Old, result.OldFound = old, true
+	}
+	if (opts.IfMissing && found) || (opts.IfExists && !found) {
+		return result, nil
+	}
+
+	entry = Entry{value: newStringValue(value)}
+	if !opts.ExpiresAt.IsZero() {
+		entry.hasExpiry = true
+		entry.expiresAt = opts.ExpiresAt
+	}
+	s.setEntry(key, entry)
+	result.Written = true
+	return result, nil
+}
+
+func (s *Store) Get(key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.lookupString(key)
+}
+
+// GetPair returns the string values of two keys read under one lock, with a
+// missing key read as the empty string.
+func (s *Store) GetPair(key1, key2 string) (string, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, _, err := s.lookupString(key1)
+	if err != nil {
+		return "", "", err
+	}
+	b, _, err := s.lookupString(key2)
+	if err != nil {
+		return "", "", err
+	}
+	return a, b, nil
+}
+
+// GetEx returns key's string value and, in the same critical section, gives
+// it the expiry expiresAt or, with persist, removes its TTL. A zero expiresAt
+// without persist leaves the TTL alone; one already in the past deletes the
+// key after reading it.
+func (s *Store) GetEx(key string, expiresAt time.Time, persist bool) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, found, err := s.lookupString(key)
+	if !found || err != nil {
+		return value, found, err
+	}
+	entry := s.data[key]
+	switch {
+	case persist:
+		entry.hasExpiry = false
+		s.setEntry(key, entry)
+	case expiresAt.IsZero():
+	case !expiresAt.After(time.Now()):
+		s.deleteEntry(key)
+	default:
+		entry.hasExpiry = true
+		entry.expiresAt = expiresAt
+		s.setEntry(key, entry)
+	}
+	return value, true, nil
+}
+
+// IncrBy adds delta to the integer held at key, treating a missing key as 0,
+// and returns the new value. The key keeps its TTL.
+func (s *Store) IncrBy(key string, delta int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, found := s.lookup(key)
+	var current int64
+	if found {
+		n, err := intOf(entry.value)
+		if err != nil {
+			return 0, err
+		}
+		current = n
+	}
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	entry.value = IntValue(current + delta)
+	s.setEntry(key, entry)
+	return current + delta, nil
+}
+
+// IncrByFloat adds incr to the float held at key, treating a missing key as 0,
+// and returns the new value as stored. The key keeps its TTL.
+func (s *Store) IncrByFloat(key string, incr float64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, found := s.lookup(key)
+	var current float64
+	if found {
+		str, err := stringOf(entry.value)
+		if err != nil {
+			return "", err
+		}
+		f, ok := parseFloat(str)
+		if !ok {
+			return "", ErrNotFloat
+		}
+		current = f
+	}
+	result := current + incr
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return "", ErrNaNOrInf
+	}
+	formatted := strconv.FormatFloat(result, 'f', -1, 64)
+	entry.value = newStringValue(formatted)
+	s.setEntry(key, entry)
+	return formatted, nil
+}
+
+// CompareAndSet replaces key's string value with value if it currently equals
+// expected, keeping its TTL. It reports whether the value was replaced.
+func (s *Store) CompareAndSet(key, expected, value string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, found, err := s.lookupString(key)
+	if !found || err != nil || current != expected {
+		return false, err
+	}
+	entry := s.data[key]
+	entry.value = newStringValue(value)
+	s.setEntry(key, entry)
+	return true, nil
+}
+
+// CompareAndDelete deletes key if its string value equals expected and reports
+// whether it did.
+func (s *Store) CompareAndDelete(key, expected string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, found, err := s.lookupString(key)
+	if !found || err != nil || current != expected {
+		return false, err
+	}
+	return s.deleteEntry(key), nil
+}
+
+// SetMax stores n at key if the key is missing or holds a smaller integer, and
+// returns the value the key holds afterwards. An existing key keeps its TTL.
+func (s *Store) SetMax(key string, n int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, found := s.lookup(key)
+	if found {
+		current, err := intOf(entry.value)
+		if err != nil {
+			return 0, err
+		}
+		if current >= n {
+			return current, nil
+		}
+	}
+	entry.value = IntValue(n)
+	s.setEntry(key, entry)
+	return n, nil
+}
+
+// SetMaxFloat is SetMax for floats. It returns the resulting value as stored.
+func (s *Store) SetMaxFloat(key string, f float64) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, found := s.lookup(key)
+	if found {
+		str, err := stringOf(entry.value)
+		if err != nil {
+			return "", err
+		}
+		current, ok := parseFloat(str)
+		if !ok {
+			return "", ErrNotFloat
+		}
+		if current >= f {
+			return str, nil
+		}
+	}
+	formatted := strconv.FormatFloat(f, 'f', -1, 64)
+	entry.value = newStringValue(formatted)
+	s.setEntry(key, entry)
+	return formatted, nil
+}
+
+func (s *Store) Del(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.deleteEntry(key)
+}
+
+func (s *Store) Exists(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, found := s.lookupRead(key)
+	return found
+}
+
+func (s *Store) Persist(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, found := s.lookup(key)
+	if !found {
+		return false
+	}
+	entry.hasExpiry = false
+	s.setEntry(key, entry)
+	return true
+}
+
+func (s *Store) FlushAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data = make(map[string]Entry)
+	s.expires = newExpireIndex()
+	s.typeCounts = make(map[string]int)
+}
+
+// Keys returns the live keys matching pattern. A positive limit stops the scan
+// once that many have been found, unless keys are sorted.
+func (s *Store) Keys(pattern string, limit int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matching := []string{}
+	now := time.Now()
+	for k, v := range s.data {
+		if v.expired(now) {
+			s.removeExpired(k)
+			continue
+		}
+		match, _ := filepath.Match(pattern, k)
+		if match {
+			matching = append(matching, k)
+			if len(matching) == limit && !s.sortedKeys {
+				break
+			}
+		}
+	}
+	if s.sortedKeys {
+		sort.Strings(matching)
+		if limit > 0 && len(matching) > limit {
+			matching = matching[:limit]
+		}
+	}
+	return matching
+}
+
+// randomKeySamples is how many random keys RANDOMKEY TYPE tries before it
+// falls back to scanning the keyspace.
+const randomKeySamples = 100
+
+// RandomKey returns a random live key, limited to values of typ unless it is
+// empty. A type no key has is answered from typeCounts; otherwise it samples
+// random keys, and only a type too rare to turn up in randomKeySamples tries
+// costs a full scan. Once seeded it always scans, since only a sorted scan
+// is reproducible.
+func (s *Store) RandomKey(typ string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if typ != "" && s.typeCounts[typ] == 0 {
+		return "", false
+	}
+	if s.seeded {
+		return s.scanRandomKey(typ)
+	}
+	for i := 0; i < randomKeySamples; i++ {
+		key, entry, found := s.anyKey()
+		if !found {
+			return "", false
+		}
+		if typ == "" || entry.value.Type() == typ {
+			return key, true
+		}
+	}
+	return s.scanRandomKey(typ)
+}
+
+// anyKey returns the first live key of a map iteration, removing expired
+// keys it passes. Go starts every iteration at a random position, so this is
+// a cheap random pick, though not a uniform one. It must be called with s.mu
+// held.
+func (s *Store) anyKey() (string, Entry, bool) {
+	now := time.Now()
+	for k, v := range s.data {
+		if v.expired(now) {
+			s.removeExpired(k)
+			continue
+		}
+		return k, v, true
+	}
+	return "", Entry{}, false
+}
+
+// scanRandomKey chooses uniformly among the live keys of typ in one
+// reservoir-sampling pass, or once seeded by sorting them first. It must be
+// called with s.mu held.
+func (s *Store) scanRandomKey(typ string) (string, bool) {
+	var chosen string
+	var candidates []string
+	seen := 0
+	now := time.Now()
+	for k, v := range s.data {
+		if v.expired(now) {
+			s.removeExpired(k)
+			continue
+		}
+		if typ != "" && v.value.Type() != typ {
+			continue
+		}
+		if s.seeded {
+			candidates = append(candidates, k)
+			continue
+		}
+		seen++
+		if s.rng.Intn(seen) == 0 {
+			chosen = k
+		}
+	}
+	if s.seeded && len(candidates) > 0 {
+		sort.Strings(candidates)
+		return candidates[s.rng.Intn(len(candidates))], true
+	}
+	return chosen, seen > 0
+}
+
+func (s *Store) Rename(oldKey, newKey string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, found := s.lookup(oldKey)
+	if !found {
+		return false
+	}
+	s.deleteEntry(oldKey)
+	s.setEntry(newKey, entry)
+	return true
+}
+
+func (s *Store) TTL(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, found := s.lookupRead(key)
+	if !found {
+		return -2
+	}
+	if !entry.hasExpiry {
+		return -1
+	}
+	return int(time.Until(entry.expiresAt).Seconds())
+}
+
+// ExpireTime returns key's absolute expiry. It reports -2 for a missing key, -1
+// for one without a TTL and 0 otherwise, matching TTL's conventions.
+func (s *Store) ExpireTime(key string) (time.Time, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, found := s.lookupRead(key)
+	if !found {
+		return time.Time{}, -2
+	}
+	if !entry.hasExpiry {
+		return time.Time{}, -1
+	}
+	return entry.expiresAt, 0
+}
+
+// WaitKey reports whether key exists and, if not, returns a channel that is
+// closed when it is next set. cancel must be called if the caller stops
+// waiting before then.
+func (s *Store) WaitKey(key string) (exists bool, set <-chan struct{}, cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, found := s.lookup(key); found {
+		return true, nil, nil
+	}
+	ch := make(chan struct{})
+	s.waiters[key] = append(s.waiters[key], ch)
+	cancel = func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		waiting := s.waiters[key]
+		for i, w := range waiting {
+			if w == ch {
+				waiting = append(waiting[:i], waiting[i+1:]...)
+				break
+			}
+		}
+		if len(waiting) == 0 {
+			delete(s.waiters, key)
+		} else {
+			s.waiters[key] = waiting
+		}
+	}
+	return false, ch, cancel
+}
+
+// Expire gives key the deadline expiresAt, deleting it right away if the
+// deadline is not in the future. It reports whether the key existed.
+func (s *Store) Expire(key string, expiresAt time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, found := s.lookup(key)
+	if !found {
+		return false
+	}
+	if !expiresAt.After(time.Now()) {
+		s.deleteEntry(key)
+		return true
+	}
+	entry.hasExpiry = true
+	entry.expiresAt = expiresAt
+	s.setEntry(key, entry)
+	return true
+}
+
+func (s *Store) ObjectEncoding(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, found := s.lookup(key)
+	if !found {
+		return "", false
+	}
+	return entry.value.Encoding(), true
+}
+
+// DebugObject describes key's internal representation for DEBUG OBJECT.
+func (s *Store) DebugObject(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, found := s.lookup(key)
+	if !found {
+		return "", false
+	}
+	return describeValue(entry.value), true
+}
+
+type StoreStats struct {
+	Keys           int
+	KeysWithExpiry int
+	ExpiredKeys    int64
+	KeyspaceHits   int64
+	KeyspaceMisses int64
+}
+
+func (s *Store) Stats() StoreStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return StoreStats{
+		Keys:           len(s.data),
+		KeysWithExpiry: s.expires.len(),
+		ExpiredKeys:    s.expiredKeys,
+		KeyspaceHits:   s.hits,
+		KeyspaceMisses: s.misses,
+	}
+}
+
+// removeExpired must be called with s.mu held.
+func (s *Store) removeExpired(key string) {
+	s.deleteEntry(key)
+	s.expiredKeys++
+}
@@ -0,0 +1,446 @@
+package cask
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	s := NewStore()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func mustSet(t *testing.T, s *Store, key, value string, opts SetOptions) {
+	t.Helper()
+	if _, err := s.Set(key, value, opts); err != nil {
+		t.Fatalf("Set(%q): %v", key, err)
+	}
+}
+
+func TestSetGet(t *testing.T) {
+	past := SetOptions{ExpiresAt: time.Now().Add(-time.Second)}
+	future := SetOptions{ExpiresAt: time.Now().Add(time.Hour)}
+
+	tests := []struct {
+		name        string
+		setup       func(t *testing.T, s *Store)
+		set         string
+		opts        SetOptions
+		wantWritten bool
+		want        string
+		wantFound   bool
+	}{
+		{name: "new key", set: "v", wantWritten: true, want: "v", wantFound: true},
+		{name: "overwrite", setup: func(t *testing.T, s *Store) { mustSet(t, s, "k", "old", SetOptions{}) },
+			set: "new", wantWritten: true, want: "new", wantFound: true},
+		{name: "integer keeps its bytes", set: "42", wantWritten: true, want: "42", wantFound: true},
+		{name: "non-canonical integer keeps its bytes", set: "007", wantWritten: true, want: "007", wantFound: true},
+		{name: "future expiry", set: "v", opts: future, wantWritten: true, want: "v", wantFound: true},
+		{name: "past expiry stores it already expired", set: "v", opts: past, wantWritten: true},
+		{name: "NX on missing key", set: "v", opts: SetOptions{IfMissing: true}, wantWritten: true, want: "v", wantFound: true},
+		{name: "NX on existing key", setup: func(t *testing.T, s *Store) { mustSet(t, s, "k", "old", SetOptions{}) },
+			set: "new", opts: SetOptions{IfMissing: true}, want: "old", wantFound: true},
+		{name: "NX on expired key", setup: func(t *testing.T, s *Store) { mustSet(t, s, "k", "old", past) },
+			set: "new", opts: SetOptions{IfMissing: true}, wantWritten: true, want: "new", wantFound: true},
+		{name: "XX on missing key", set: "v", opts: SetOptions{IfExists: true}},
+		{name: "XX on expired key", setup: func(t *testing.T, s *Store) { mustSet(t, s, "k", "old", past) },
+			set: "new", opts: SetOptions{IfExists: true}},
+		{name: "XX on existing key", setup: func(t *testing.T, s *Store) { mustSet(t, s, "k", "old", SetOptions{}) },
+			set: "new", opts: SetOptions{IfExists: true}, wantWritten: true, want: "new", wantFound: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			if tt.setup != nil {
+				tt.setup(t, s)
+			}
+			result, err := s.Set("k", tt.set, tt.opts)
+			if err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+			if result.Written != tt.wantWritten {
+				t.Errorf("Set written = %v, want %v", result.Written, tt.wantWritten)
+			}
+			got, found, err := s.Get("k")
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if got != tt.want || found != tt.wantFound {
+				t.Errorf("Get = %q, %v, want %q, %v", got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+}
+
+func TestSetGetOldValue(t *testing.T) {
+	s := newTestStore(t)
+	mustSet(t, s, "k", "old", SetOptions{})
+	result, err := s.Set("k", "new", SetOptions{IfMissing: true, Get: true})
+	if err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if result.Written || result.Old != "old" || !result.OldFound {
+		t.Errorf("Set NX GET = %+v, want unwritten with old value", result)
+	}
+
+	if _, err := s.HSet("h", []string{"f", "v"}); err != nil {
+		t.Fatalf("HSet: %v", err)
+	}
+	if _, err := s.Set("h", "v", SetOptions{Get: true}); err != ErrWrongType {
+		t.Errorf("Set GET on a hash = %v, want ErrWrongType", err)
+	}
+	if _, _, err := s.Get("h"); err != ErrWrongType {
+		t.Errorf("Get on a hash = %v, want ErrWrongType", err)
+	}
+}
+
+func TestDel(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, s *Store)
+		want  bool
+	}{
+		{name: "missing key", want: false},
+		{name: "existing key", setup: func(t *testing.T, s *Store) { mustSet(t, s, "k", "v", SetOptions{}) }, want: true},
+		{name: "key with TTL", setup: func(t *testing.T, s *Store) {
+			mustSet(t, s, "k", "v", SetOptions{ExpiresAt: time.Now().Add(time.Hour)})
+		}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			if tt.setup != nil {
+				tt.setup(t, s)
+			}
+			if got := s.Del("k"); got != tt.want {
+				t.Errorf("Del = %v, want %v", got, tt.want)
+			}
+			if s.Exists("k") {
+				t.Error("key still exists after Del")
+			}
+			if s.Del("k") {
+				t.Error("second Del = true, want false")
+			}
+			if n := s.Stats().KeysWithExpiry; n != 0 {
+				t.Errorf("KeysWithExpiry = %d after Del, want 0", n)
+			}
+		})
+	}
+}
+
+func TestExpire(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(t *testing.T, s *Store)
+		deadline   time.Duration
+		want       bool
+		wantExists bool
+	}{
+		{name: "missing key", deadline: time.Hour, want: false},
+		{name: "future deadline", setup: func(t *testing.T, s *Store) { mustSet(t, s, "k", "v", SetOptions{}) },
+			deadline: time.Hour, want: true, wantExists: true},
+		{name: "past deadline deletes", setup: func(t *testing.T, s *Store) { mustSet(t, s, "k", "v", SetOptions{}) },
+			deadline: -time.Second, want: true},
+		{name: "deadline of now deletes", setup: func(t *testing.T, s *Store) { mustSet(t, s, "k", "v", SetOptions{}) },
+			deadline: 0, want: true},
+		{name: "replaces an existing TTL", setup: func(t *testing.T, s *Store) {
+			mustSet(t, s, "k", "v", SetOptions{ExpiresAt: time.Now().Add(time.Second)})
+		}, deadline: time.Hour, want: true, wantExists: true},
+		{name: "already expired key", setup: func(t *testing.T, s *Store) {
+			mustSet(t, s, "k", "v", SetOptions{ExpiresAt: time.Now().Add(-time.Second)})
+		}, deadline: time.Hour, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			if tt.setup != nil {
+				tt.setup(t, s)
+			}
+			if got := s.Expire("k", time.Now().Add(tt.deadline)); got != tt.want {
+				t.Errorf("Expire = %v, want %v", got, tt.want)
+			}
+			if got := s.Exists("k"); got != tt.wantExists {
+				t.Errorf("Exists = %v, want %v", got, tt.wantExists)
+			}
+		})
+	}
+}
+
+func TestTTL(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, s *Store)
+		min   int
+		max   int
+	}{
+		{name: "missing key", min: -2, max: -2},
+		{name: "no expiry", setup: func(t *testing.T, s *Store) { mustSet(t, s, "k", "v", SetOptions{}) }, min: -1, max: -1},
+		{name: "with expiry", setup: func(t *testing.T, s *Store) {
+			mustSet(t, s, "k", "v", SetOptions{ExpiresAt: time.Now().Add(100 * time.Second)})
+		}, min: 99, max: 100},
+		{name: "expired", setup: func(t *testing.T, s *Store) {
+			mustSet(t, s, "k", "v", SetOptions{ExpiresAt: time.Now().Add(-time.Millisecond)})
+		}, min: -2, max: -2},
+		{name: "set without TTL clears it", setup: func(t *testing.T, s *Store) {
+			mustSet(t, s, "k", "v", SetOptions{ExpiresAt: time.Now().Add(time.Hour)})
+			mustSet(t, s, "k", "v", SetOptions{})
+		}, min: -1, max: -1},
+		{name: "persist clears it", setup: func(t *testing.T, s *Store) {
+			mustSet(t, s, "k", "v", SetOptions{ExpiresAt: time.Now().Add(time.Hour)})
+			s.Persist("k")
+		}, min: -1, max: -1},
+		{name: "expire sets it", setup: func(t *testing.T, s *Store) {
+			mustSet(t, s, "k", "v", SetOptions{})
+			s.Expire("k", time.Now().Add(10*time.Second))
+		}, min: 9, max: 10},
+		{name: "incr keeps it", setup: func(t *testing.T, s *Store) {
+			mustSet(t, s, "k", "1", SetOptions{ExpiresAt: time.Now().Add(10 * time.Second)})
+			s.IncrBy("k", 1)
+		}, min: 9, max: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStore(t)
+			if tt.setup != nil {
+				tt.setup(t, s)
+			}
+			if got := s.TTL("k"); got < tt.min || got > tt.max {
+				t.Errorf("TTL = %d, want %d..%d", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestKeys(t *testing.T) {
+	s := newTestStore(t)
+	for _, key := range []string{"user:1", "user:2", "user:10", "session:a", "other"} {
+		mustSet(t, s, key, "v", SetOptions{})
+	}
+	mustSet(t, s, "user:expired", "v", SetOptions{ExpiresAt: time.Now().Add(-time.Second)})
+
+	tests := []struct {
+		pattern string
+		limit   int
+		want    []string
+	}{
+		{pattern: "*", want: []string{"other", "session:a", "user:1", "user:10", "user:2"}},
+		{pattern: "user:*", want: []string{"user:1", "user:10", "user:2"}},
+		{pattern: "user:?", want: []string{"user:1", "user:2"}},
+		{pattern: "[os]*", want: []string{"other", "session:a"}},
+		{pattern: "nothing*", want: []string{}},
+		{pattern: "other", want: []string{"other"}},
+		{pattern: "*", limit: 2, want: []string{"other", "session:a"}},
+	}
+	s.SetSortedKeys(true)
+	for _, tt := range tests {
+		got := s.Keys(tt.pattern, tt.limit)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Keys(%q, %d) = %q, want %q", tt.pattern, tt.limit, got, tt.want)
+		}
+	}
+
+	s.SetSortedKeys(false)
+	got := s.Keys("user:*", 2)
+	if len(got) != 2 {
+		t.Fatalf("unsorted Keys with limit 2 returned %q", got)
+	}
+	sort.Strings(got)
+	for _, key := range got {
+		if key != "user:1" && key != "user:10" && key != "user:2" {
+			t.Errorf("unsorted Keys with limit returned non-matching %q", key)
+		}
+	}
+}
+
+func TestExpiredKeysAreReaped(t *testing.T) {
+	s := newTestStore(t)
+	mustSet(t, s, "short", "v", SetOptions{ExpiresAt: time.Now().Add(10 * time.Millisecond)})
+	mustSet(t, s, "long", "v", SetOptions{ExpiresAt: time.Now().Add(time.Hour)})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for s.Stats().Keys != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expired key not removed without being accessed: %+v", s.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	stats := s.Stats()
+	if stats.ExpiredKeys != 1 || stats.KeysWithExpiry != 1 {
+		t.Errorf("Stats = %+v, want 1 expired and 1 key with expiry", stats)
+	}
+}
+
+func BenchmarkIncr(b *testing.B) {
+	s := NewStore()
+	defer s.Close()
+	for i := 0; i < b.N; i++ {
+		if _, err := s.IncrBy("counter", 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkIncrParallel(b *testing.B) {
+	s := NewStore()
+	defer s.Close()
+	keys := make([]string, 64)
+	for i := range keys {
+		keys[i] = "counter:" + strconv.Itoa(i)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := s.IncrBy(keys[i%len(keys)], 1); err != nil {
+				b.Error(err)
+				return
+			}
+			i++
+		}
+	})
+}
+
+// BenchmarkIncrThenGet mixes INCR with GET, which renders the int encoding
+// back to a string.
+func BenchmarkIncrThenGet(b *testing.B) {
+	s := NewStore()
+	defer s.Close()
+	for i := 0; i < b.N; i++ {
+		s.IncrBy("counter", 1)
+		if _, _, err := s.Get("counter"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSetInt(b *testing.B) {
+	benchmarkSet(b, "1234567")
+}
+
+func BenchmarkSetRaw(b *testing.B) {
+	benchmarkSet(b, "abcdefg")
+}
+
+func benchmarkSet(b *testing.B, value string) {
+	s := NewStore()
+	defer s.Close()
+	for i := 0; i < b.N; i++ {
+		s.Set("k", value, SetOptions{})
+	}
+}
+
+func TestRandomKey(t *testing.T) {
+	s := newTestStore(t)
+	if _, found := s.RandomKey(""); found {
+		t.Error("RandomKey on an empty store found a key")
+	}
+
+	for i := 0; i < 1000; i++ {
+		mustSet(t, s, "str:"+strconv.Itoa(i), "v", SetOptions{})
+	}
+	mustSet(t, s, "gone", "v", SetOptions{ExpiresAt: time.Now().Add(-time.Second)})
+	if _, err := s.ListPush("list", []string{"a"}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		key, found := s.RandomKey("")
+		if !found || key == "gone" {
+			t.Fatalf("RandomKey = %q, %v", key, found)
+		}
+	}
+	// One list among a thousand strings is too rare to sample, so this
+	// exercises the fallback scan.
+	if key, found := s.RandomKey("list"); key != "list" || !found {
+		t.Errorf("RandomKey(list) = %q, %v", key, found)
+	}
+	if key, found := s.RandomKey("set"); found {
+		t.Errorf("RandomKey(set) = %q with no sets", key)
+	}
+	if key, found := s.RandomKey("string"); !found || key == "list" {
+		t.Errorf("RandomKey(string) = %q, %v", key, found)
+	}
+
+	s.ListPop("list", 1, false)
+	if key, found := s.RandomKey("list"); found {
+		t.Errorf("RandomKey(list) = %q after the list was emptied", key)
+	}
+	s.FlushAll()
+	if key, found := s.RandomKey("string"); found {
+		t.Errorf("RandomKey(string) = %q after FLUSHALL", key)
+	}
+}
+
+func TestRandomKeySeeded(t *testing.T) {
+	picks := func() []string {
+		s := newTestStore(t)
+		for i := 0; i < 50; i++ {
+			mustSet(t, s, "k"+strconv.Itoa(i), "v", SetOptions{})
+		}
+		s.Seed(42)
+		var keys []string
+		for i := 0; i < 10; i++ {
+			key, _ := s.RandomKey("")
+			keys = append(keys, key)
+		}
+		return keys
+	}
+	if a, b := picks(), picks(); !reflect.DeepEqual(a, b) {
+		t.Errorf("seeded RandomKey not reproducible: %q vs %q", a, b)
+	}
+}
+
+func BenchmarkRandomKey(b *testing.B) {
+	s := NewStore()
+	defer s.Close()
+	for i := 0; i < 100000; i++ {
+		s.Set("k"+strconv.Itoa(i), "v", SetOptions{})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.RandomKey("")
+	}
+}
+
+// TestIntegerCommandsAgree checks that INCRBY and SETMAX accept the same
+// stored values as integers.
+func TestIntegerCommandsAgree(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr error
+	}{
+		{"5", nil},
+		{"-5", nil},
+		{"0", nil},
+		{"007", ErrNotInt},
+		{"+5", ErrNotInt},
+		{" 5", ErrNotInt},
+		{"5.0", ErrNotInt},
+		{"abc", ErrNotInt},
+		{"", ErrNotInt},
+	}
+	for _, tt := range tests {
+		s := newTestStore(t)
+		mustSet(t, s, "incr", tt.value, SetOptions{})
+		mustSet(t, s, "max", tt.value, SetOptions{})
+		if _, err := s.IncrBy("incr", 1); err != tt.wantErr {
+			t.Errorf("IncrBy on %q: error %v, want %v", tt.value, err, tt.wantErr)
+		}
+		if _, err := s.SetMax("max", 100); err != tt.wantErr {
+			t.Errorf("SetMax on %q: error %v, want %v", tt.value, err, tt.wantErr)
+		}
+	}
+
+	s := newTestStore(t)
+	s.SAdd("set", []string{"m"})
+	if _, err := s.IncrBy("set", 1); err != ErrWrongType {
+		t.Errorf("IncrBy on a set: error %v, want ErrWrongType", err)
+	}
+	if _, err := s.SetMax("set", 1); err != ErrWrongType {
+		t.Errorf("SetMax on a set: error %v, want ErrWrongType", err)
+	}
+}
@@ -0,0 +1,12 @@
+package cask
+
+// Version and BuildDate are set at build time with -ldflags
+// "-X github.com/olivemonk/cask.Version=... -X github.com/olivemonk/cask.BuildDate=...".
+var (
+	Version   = "dev"
+	BuildDate = "unknown"
+)
+
+// redisVersion is the Redis release whose commands cask implements, reported
+// for clients that gate features on it.
+const redisVersion = "7.0.0"